@@ -0,0 +1,123 @@
+package jsonstorage
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/thamaji/fstools"
+)
+
+// Backup writes the JSONs in the storage to w as a tar archive, keeping their
+// paths relative to the storage directory and their bytes as stored.
+func (storage *Storage[T]) Backup(w io.Writer) error {
+	unlock := storage.rlockAll()
+	defer unlock()
+
+	names, err := storage.names()
+	if err != nil {
+		return fmt.Errorf("%w: failed to back up JSONs: %s", ErrInternal, err)
+	}
+
+	if storage.dedup {
+		blobNames, err := storage.blobNames()
+		if err != nil {
+			return fmt.Errorf("%w: failed to back up JSONs: %s", ErrInternal, err)
+		}
+		names = append(names, blobNames...)
+	}
+
+	tw := tar.NewWriter(w)
+
+	for _, name := range names {
+		err := fstools.ReadFileFunc(filepath.Join(storage.dir, name), func(r io.Reader) error {
+			info, err := r.(*os.File).Stat()
+			if err != nil {
+				return err
+			}
+
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			header.Name = filepath.ToSlash(name)
+
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+
+			_, err = io.Copy(tw, r)
+			return err
+		})
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return fmt.Errorf("%w: failed to back up JSONs: %s", ErrInternal, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("%w: failed to back up JSONs: %s", ErrInternal, err)
+	}
+
+	return nil
+}
+
+// Restore extracts a tar archive written by Backup from r into the storage
+// directory, overwriting existing JSONs. Entries that are not JSONs of the
+// storage or that would escape its directory are skipped. With WithDedup, the
+// reference counts of the archive are skipped as well and the ones of the
+// storage are recounted from its entries once extracted.
+func (storage *Storage[T]) Restore(r io.Reader) error {
+	if err := storage.writable(); err != nil {
+		return err
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	storage.cache.clear()
+
+	tr := tar.NewReader(r)
+
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("%w: failed to restore JSONs: %s", ErrInternal, err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := filepath.FromSlash(header.Name)
+		blob := storage.dedup && filepath.Dir(name) == blobDir && !strings.HasSuffix(name, refsExt)
+		if !filepath.IsLocal(name) || (!strings.HasSuffix(name, storage.ext) && !blob) {
+			continue
+		}
+
+		err = storage.writeFile(filepath.Join(storage.dir, name), func(w io.Writer) error {
+			_, err := io.Copy(w, tr)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%w: failed to restore JSONs: %s", ErrInternal, err)
+		}
+	}
+
+	if storage.dedup && !storage.flat {
+		if err := storage.recountRefs(); err != nil {
+			return fmt.Errorf("%w: failed to restore JSONs: %s", ErrInternal, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package jsonstorage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/thamaji/fstools"
+)
+
+// buffered is a write held in memory by WithWriteBuffer until Flush. at is
+// when it was buffered, standing in for the modification time of its file
+// with WithMaxEntries.
+type buffered[T any] struct {
+	ent     entry[T]
+	deleted bool
+	at      time.Time
+}
+
+func (storage *Storage[T]) buffered(path string) (buffered[T], bool) {
+	if storage.buffer == nil {
+		return buffered[T]{}, false
+	}
+
+	storage.bufferMutex.Lock()
+	defer storage.bufferMutex.Unlock()
+
+	b, ok := storage.buffer[path]
+	return b, ok
+}
+
+func (storage *Storage[T]) bufferPut(path string, b buffered[T]) {
+	storage.bufferMutex.Lock()
+	defer storage.bufferMutex.Unlock()
+
+	storage.buffer[path] = b
+}
+
+// unbuffer drops the write buffered at path, for a write to its file that
+// supersedes it.
+func (storage *Storage[T]) unbuffer(path string) {
+	if storage.buffer == nil {
+		return
+	}
+
+	storage.bufferMutex.Lock()
+	defer storage.bufferMutex.Unlock()
+
+	delete(storage.buffer, path)
+}
+
+// exists reports whether there is an entry at path, in the write buffer or on
+// disk.
+func (storage *Storage[T]) exists(path string) bool {
+	if b, ok := storage.buffered(path); ok {
+		return !b.deleted
+	}
+	return fstools.Exists(path)
+}
+
+// bufferNames merges the write buffer into names listed from disk, dropping
+// the deleted ones and adding the ones not flushed yet.
+func (storage *Storage[T]) bufferNames(names []string) []string {
+	if storage.buffer == nil {
+		return names
+	}
+
+	storage.bufferMutex.Lock()
+	defer storage.bufferMutex.Unlock()
+
+	if len(storage.buffer) == 0 {
+		return names
+	}
+
+	merged := make([]string, 0, len(names)+len(storage.buffer))
+	seen := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		path := filepath.Join(storage.dir, name)
+		seen[path] = struct{}{}
+		if b, ok := storage.buffer[path]; ok && b.deleted {
+			continue
+		}
+		merged = append(merged, name)
+	}
+
+	added := []string{}
+	for path, b := range storage.buffer {
+		if _, ok := seen[path]; ok || b.deleted {
+			continue
+		}
+		name, err := filepath.Rel(storage.dir, path)
+		if err != nil {
+			continue
+		}
+		added = append(added, name)
+	}
+	sort.Strings(added)
+
+	return append(merged, added...)
+}
+
+// Flush writes the writes buffered by WithWriteBuffer to disk under the write
+// lock. Writes that fail stay buffered and the first failure is returned.
+func (storage *Storage[T]) Flush() error {
+	if storage.buffer == nil {
+		return nil
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	return storage.flush()
+}
+
+// flush is Flush without locking, for callers holding the write lock.
+func (storage *Storage[T]) flush() error {
+	if storage.buffer == nil {
+		return nil
+	}
+
+	storage.bufferMutex.Lock()
+	pending := storage.buffer
+	storage.buffer = map[string]buffered[T]{}
+	storage.bufferMutex.Unlock()
+
+	var firstErr error
+	for path, b := range pending {
+		var err error
+		if b.deleted {
+			storage.cache.remove(path)
+			if err = storage.removeFile(path); errors.Is(err, os.ErrNotExist) {
+				err = nil
+			}
+		} else {
+			err = storage.putFile(path, b.ent)
+		}
+
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%w: failed to flush JSONs: %s", ErrInternal, err)
+			}
+			if _, ok := storage.buffered(path); !ok {
+				storage.bufferPut(path, b)
+			}
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,89 @@
+package jsonstorage
+
+import (
+	"container/list"
+	"sync"
+)
+
+// cache is a capacity-bounded LRU of decoded entries keyed by path.
+// A nil *cache is valid and caches nothing.
+type cache[T any] struct {
+	mutex sync.Mutex
+	max   int
+	list  *list.List
+	items map[string]*list.Element
+}
+
+type cacheItem[T any] struct {
+	path string
+	ent  entry[T]
+}
+
+func newCache[T any](max int) *cache[T] {
+	return &cache[T]{max: max, list: list.New(), items: map[string]*list.Element{}}
+}
+
+func (c *cache[T]) get(path string) (entry[T], bool) {
+	if c == nil {
+		return entry[T]{}, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, ok := c.items[path]
+	if !ok {
+		return entry[T]{}, false
+	}
+	c.list.MoveToFront(elem)
+
+	return elem.Value.(*cacheItem[T]).ent, true
+}
+
+func (c *cache[T]) set(path string, ent entry[T]) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, ok := c.items[path]; ok {
+		elem.Value.(*cacheItem[T]).ent = ent
+		c.list.MoveToFront(elem)
+		return
+	}
+
+	c.items[path] = c.list.PushFront(&cacheItem[T]{path: path, ent: ent})
+	for c.list.Len() > c.max {
+		elem := c.list.Back()
+		c.list.Remove(elem)
+		delete(c.items, elem.Value.(*cacheItem[T]).path)
+	}
+}
+
+func (c *cache[T]) remove(path string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, ok := c.items[path]; ok {
+		c.list.Remove(elem)
+		delete(c.items, path)
+	}
+}
+
+func (c *cache[T]) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.list.Init()
+	c.items = map[string]*list.Element{}
+}
@@ -0,0 +1,45 @@
+package jsonstorage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// checksum returns the SHA-256 of raw, a value as encoded in a file. raw is
+// compacted and HTML-escaped first, so that the checksum does not depend on
+// WithIndent or WithDisableHTMLEscape.
+func checksum(raw []byte) (string, error) {
+	compact := bytes.Buffer{}
+	if err := json.Compact(&compact, raw); err != nil {
+		return "", err
+	}
+
+	escaped := bytes.Buffer{}
+	json.HTMLEscape(&escaped, compact.Bytes())
+
+	sum := sha256.Sum256(escaped.Bytes())
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// verifyChecksum compares sum, the checksum stored with the entry of key, to
+// the one of raw, its value as read from the file. Entries written without
+// checksum are not verified.
+func (storage *Storage[T]) verifyChecksum(key string, sum string, raw []byte) error {
+	if !storage.checksum || sum == "" {
+		return nil
+	}
+
+	actual, err := checksum(raw)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrChecksumMismatch, key, err)
+	}
+
+	if actual != sum {
+		return fmt.Errorf("%w: %s", ErrChecksumMismatch, key)
+	}
+
+	return nil
+}
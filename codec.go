@@ -0,0 +1,80 @@
+package jsonstorage
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Codec encodes and decodes the files in the storage.
+// Ext returns the file extension including the leading dot, e.g. ".yaml".
+type Codec interface {
+	Encode(w io.Writer, v any) error
+	Decode(r io.Reader, v any) error
+	Ext() string
+}
+
+type jsonCodec struct {
+	prefix       string
+	indent       string
+	noEscapeHTML bool
+	configure    func(*json.Decoder)
+}
+
+func (codec jsonCodec) Encode(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent(codec.prefix, codec.indent)
+	enc.SetEscapeHTML(!codec.noEscapeHTML)
+	return enc.Encode(v)
+}
+
+func (codec jsonCodec) Decode(r io.Reader, v any) error {
+	dec := json.NewDecoder(r)
+	if codec.configure != nil {
+		codec.configure(dec)
+	}
+	return dec.Decode(v)
+}
+
+func (jsonCodec) Ext() string {
+	return ".json"
+}
+
+// newDecoder returns a json.Decoder reading r, configured by
+// WithDecoderOptions, for the JSON decoded outside of the codec.
+func (storage *Storage[T]) newDecoder(r io.Reader) *json.Decoder {
+	dec := json.NewDecoder(r)
+	if storage.decoderOptions != nil {
+		storage.decoderOptions(dec)
+	}
+	return dec
+}
+
+// errReader records the failure of r, telling failures to read the file from
+// failures to decode what was read.
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (er *errReader) Read(p []byte) (int, error) {
+	n, err := er.r.Read(p)
+	if err != nil && err != io.EOF {
+		er.err = err
+	}
+	return n, err
+}
+
+// errWriter records the failure of w, telling failures to write the file
+// from failures to encode.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) Write(p []byte) (int, error) {
+	n, err := ew.w.Write(p)
+	if err != nil {
+		ew.err = err
+	}
+	return n, err
+}
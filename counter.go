@@ -0,0 +1,48 @@
+package jsonstorage
+
+import (
+	"errors"
+	"os"
+)
+
+// IncrementInt adds delta to the counter stored at key and returns the new
+// value. A missing key counts from zero. The read and the write happen under
+// the write lock of key, so concurrent increments are not lost.
+func IncrementInt(storage *Storage[int64], key string, delta int64) (int64, error) {
+	if err := storage.writable(); err != nil {
+		return 0, err
+	}
+
+	if err := storage.checkKey(key); err != nil {
+		return 0, err
+	}
+
+	path := storage.path(key)
+
+	unlock := storage.lockKey(path)
+	defer unlock()
+
+	ent, err := storage.get(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, &InternalError{Key: key, Msg: "failed to increment JSON", Err: err}
+	}
+
+	value := ent.Value + delta
+
+	if err := storage.validate(key, value); err != nil {
+		return 0, err
+	}
+
+	if err := storage.beforePut(key, value); err != nil {
+		return 0, err
+	}
+
+	err = storage.put(path, entry[int64]{Key: key, Value: value, entryMeta: ent.entryMeta})
+	if err != nil {
+		return 0, &InternalError{Key: key, Msg: "failed to increment JSON", Err: err}
+	}
+
+	storage.afterPut(key, value)
+
+	return value, nil
+}
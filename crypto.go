@@ -0,0 +1,47 @@
+package jsonstorage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// encrypt writes the output of f sealed with AES-GCM to w, prefixed with a
+// random nonce.
+func (storage *Storage[T]) encrypt(w io.Writer, f func(io.Writer) error) error {
+	buf := bytes.Buffer{}
+	if err := f(&buf); err != nil {
+		return err
+	}
+
+	nonce := make([]byte, storage.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	_, err := w.Write(storage.aead.Seal(nonce, nonce, buf.Bytes(), nil))
+	return err
+}
+
+// decrypt reads a nonce-prefixed AES-GCM ciphertext from r and returns the
+// plaintext.
+func (storage *Storage[T]) decrypt(r io.Reader) (io.Reader, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	size := storage.aead.NonceSize()
+	if len(b) < size {
+		return nil, errors.New("failed to decrypt: ciphertext too short")
+	}
+
+	plain, err := storage.aead.Open(nil, b[:size], b[size:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: wrong key or corrupt data: %w", err)
+	}
+
+	return bytes.NewReader(plain), nil
+}
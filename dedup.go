@@ -0,0 +1,284 @@
+package jsonstorage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/thamaji/fstools"
+)
+
+// blobDir is the subdirectory keeping the values shared with WithDedup. Its
+// name is never a shard directory nor ends with the extension.
+const blobDir = "blobs"
+
+// refsExt is appended to the blob file name for the file holding how many
+// entries point at the blob.
+const refsExt = ".refs"
+
+// pointer is what an entry file holds with WithDedup: its value is replaced
+// by the hash of the blob in entryMeta.
+type pointer struct {
+	Key string `json:"key"`
+	entryMeta
+}
+
+func (storage *Storage[T]) blobPath(hash string) string {
+	return filepath.Join(storage.dir, blobDir, hash)
+}
+
+// putDedup writes the entry of key as a pointer to the blob of value, storing
+// the blob if no other entry shares it and releasing the blob the entry
+// pointed at. Values are hashed in their plain codec encoding, so that
+// identical values share a blob even when they are compressed or encrypted.
+func (storage *Storage[T]) putDedup(path string, key string, meta entryMeta, value any) error {
+	buf := bytes.Buffer{}
+	if err := storage.codec.Encode(&buf, value); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	hash := hex.EncodeToString(sum[:])
+
+	old, err := storage.blobOf(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if old != hash {
+		if err := storage.retain(hash, value); err != nil {
+			return err
+		}
+	}
+
+	meta.Blob = hash
+	err = storage.writeFile(path, func(w io.Writer) error {
+		return storage.encode(w, pointer{Key: key, entryMeta: meta})
+	})
+	if err != nil {
+		if old != hash {
+			storage.release(hash)
+		}
+		return err
+	}
+
+	if old != "" && old != hash {
+		return storage.release(old)
+	}
+
+	return nil
+}
+
+// unlink removes the entry file at path, releasing its blob with WithDedup.
+func (storage *Storage[T]) unlink(path string) error {
+	if !storage.dedup {
+		return os.Remove(path)
+	}
+
+	hash, err := storage.blobOf(path)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+
+	if hash != "" {
+		return storage.release(hash)
+	}
+
+	return nil
+}
+
+// blobOf returns the hash of the blob the entry file at path points at, or
+// "" if it holds its value itself.
+func (storage *Storage[T]) blobOf(path string) (string, error) {
+	if storage.flat {
+		return "", nil
+	}
+
+	ent := entry[json.RawMessage]{}
+	err := fstools.ReadFileFunc(path, func(r io.Reader) error {
+		return storage.decode(r, &ent)
+	})
+	return ent.Blob, err
+}
+
+// readBlob decodes the value of the blob of hash into v. A missing blob is
+// reported as ErrDecode, since the entry pointing at it is corrupt rather than
+// absent.
+func (storage *Storage[T]) readBlob(hash string, v any) error {
+	err := fstools.ReadFileFunc(storage.blobPath(hash), func(r io.Reader) error {
+		return storage.decode(r, v)
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: missing blob: %s", ErrDecode, hash)
+	}
+	return err
+}
+
+// retain adds a reference to the blob of hash, storing value as the blob if
+// it is not referenced yet.
+func (storage *Storage[T]) retain(hash string, value any) error {
+	storage.blobMutex.Lock()
+	defer storage.blobMutex.Unlock()
+
+	refs, err := storage.refs(hash)
+	if err != nil {
+		return err
+	}
+
+	if refs == 0 {
+		err := storage.writeFile(storage.blobPath(hash), func(w io.Writer) error {
+			return storage.encode(w, value)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return storage.setRefs(hash, refs+1)
+}
+
+// release drops a reference to the blob of hash, removing the blob with its
+// last reference.
+func (storage *Storage[T]) release(hash string) error {
+	storage.blobMutex.Lock()
+	defer storage.blobMutex.Unlock()
+
+	refs, err := storage.refs(hash)
+	if err != nil {
+		return err
+	}
+
+	if refs > 1 {
+		return storage.setRefs(hash, refs-1)
+	}
+
+	path := storage.blobPath(hash)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(path + refsExt); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (storage *Storage[T]) refs(hash string) (int, error) {
+	data, err := os.ReadFile(storage.blobPath(hash) + refsExt)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+func (storage *Storage[T]) setRefs(hash string, refs int) error {
+	return storage.writeFile(storage.blobPath(hash)+refsExt, func(w io.Writer) error {
+		_, err := io.WriteString(w, strconv.Itoa(refs))
+		return err
+	})
+}
+
+// recountRefs sets the reference count of every blob to the number of entry
+// files and tombstones pointing at it, removing the blobs none points at and
+// the counts left without a blob. Entry files that fail to decode point at
+// nothing.
+func (storage *Storage[T]) recountRefs() error {
+	storage.blobMutex.Lock()
+	defer storage.blobMutex.Unlock()
+
+	names := []string{}
+	for _, suffix := range []string{storage.ext, storage.ext + tombstoneExt} {
+		if err := storage.walk("", storage.walkDepth(), suffix, &names); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	refs := map[string]int{}
+	for _, name := range names {
+		hash, err := storage.blobOf(filepath.Join(storage.dir, name))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) || errors.Is(err, ErrDecode) {
+				continue
+			}
+			return err
+		}
+		if hash != "" {
+			refs[hash]++
+		}
+	}
+
+	blobNames, err := storage.blobNames()
+	if err != nil {
+		return err
+	}
+
+	blobs := map[string]bool{}
+	for _, name := range blobNames {
+		if !strings.HasSuffix(name, refsExt) {
+			blobs[filepath.Base(name)] = true
+		}
+	}
+
+	for _, name := range blobNames {
+		hash := strings.TrimSuffix(filepath.Base(name), refsExt)
+		if strings.HasSuffix(name, refsExt) {
+			if !blobs[hash] {
+				if err := os.Remove(storage.blobPath(hash) + refsExt); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+			}
+			continue
+		}
+
+		if refs[hash] == 0 {
+			if err := os.Remove(storage.blobPath(hash)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			if err := os.Remove(storage.blobPath(hash) + refsExt); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+
+		if err := storage.setRefs(hash, refs[hash]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// blobNames lists the paths of the blobs and their reference counts relative
+// to the storage directory.
+func (storage *Storage[T]) blobNames() ([]string, error) {
+	direntries, err := fstools.ReadDir(filepath.Join(storage.dir, blobDir))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	names := make([]string, 0, len(direntries))
+	for _, direntry := range direntries {
+		if direntry.IsDir() {
+			continue
+		}
+		names = append(names, filepath.Join(blobDir, direntry.Name()))
+	}
+	return names, nil
+}
@@ -3,6 +3,45 @@ package jsonstorage
 import "errors"
 
 var (
-	ErrNotExist = errors.New("entry does not exist")
-	ErrInternal = errors.New("internal error")
+	ErrNotExist         = errors.New("entry does not exist")
+	ErrAlreadyExists    = errors.New("entry already exists")
+	ErrInternal         = errors.New("internal error")
+	ErrInvalidKey       = errors.New("invalid key")
+	ErrInvalidValue     = errors.New("invalid value")
+	ErrVersionConflict  = errors.New("version conflict")
+	ErrReadOnly         = errors.New("storage is read-only")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrClosed           = errors.New("storage is closed")
+	ErrDecode           = errors.New("failed to decode")
+	ErrEncode           = errors.New("failed to encode")
 )
+
+// NotExistError reports the key of an entry that does not exist.
+// It unwraps to ErrNotExist.
+type NotExistError struct {
+	Key string
+}
+
+func (err *NotExistError) Error() string {
+	return ErrNotExist.Error() + ": " + err.Key
+}
+
+func (err *NotExistError) Unwrap() error {
+	return ErrNotExist
+}
+
+// InternalError reports a failed operation on the entry of Key.
+// It unwraps to ErrInternal and to Err, e.g. ErrDecode for a corrupt JSON.
+type InternalError struct {
+	Key string
+	Msg string
+	Err error
+}
+
+func (err *InternalError) Error() string {
+	return ErrInternal.Error() + ": " + err.Msg + ": " + err.Key + ": " + err.Err.Error()
+}
+
+func (err *InternalError) Unwrap() []error {
+	return []error{ErrInternal, err.Err}
+}
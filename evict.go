@@ -0,0 +1,99 @@
+package jsonstorage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// EvictionPolicy chooses the entries removed by WithMaxEntries to make room.
+type EvictionPolicy int
+
+const (
+	// EvictOldest removes the entries written least recently, by the
+	// modification time of their files.
+	EvictOldest EvictionPolicy = iota
+	// EvictLRU removes the entries read or written least recently. Uses are
+	// only tracked in memory, so after a restart they fall back to the
+	// modification time.
+	EvictLRU
+)
+
+// touch records that the entry at path was read or written, for EvictLRU.
+func (storage *Storage[T]) touch(path string) {
+	if storage.maxEntries > 0 && storage.evictionPolicy == EvictLRU {
+		storage.accessed.Store(path, storage.now())
+	}
+}
+
+// evict removes entries according to the eviction policy so that putting path
+// does not exceed WithMaxEntries. Overwriting an existing entry never evicts.
+// Entries in the write buffer count as well, and are evicted by buffering
+// their deletion. It must be called under the write lock.
+func (storage *Storage[T]) evict(path string) error {
+	if storage.maxEntries <= 0 {
+		return nil
+	}
+
+	if storage.exists(path) {
+		return nil
+	}
+
+	names, err := storage.names()
+	if err != nil {
+		return err
+	}
+
+	excess := len(names) - storage.maxEntries + 1
+	if excess <= 0 {
+		return nil
+	}
+
+	type candidate struct {
+		path string
+		used time.Time
+	}
+
+	candidates := make([]candidate, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(storage.dir, name)
+
+		used := time.Time{}
+		if b, ok := storage.buffered(path); ok {
+			used = b.at
+		} else {
+			info, err := os.Stat(path)
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				return err
+			}
+			used = info.ModTime()
+		}
+
+		if storage.evictionPolicy == EvictLRU {
+			if accessed, ok := storage.accessed.Load(path); ok && accessed.(time.Time).After(used) {
+				used = accessed.(time.Time)
+			}
+		}
+
+		candidates = append(candidates, candidate{path: path, used: used})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].used.Before(candidates[j].used)
+	})
+
+	for _, candidate := range candidates[:min(excess, len(candidates))] {
+		storage.cache.remove(candidate.path)
+		storage.accessed.Delete(candidate.path)
+		if err := storage.remove(candidate.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,255 @@
+package jsonstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/thamaji/fstools"
+)
+
+// Export writes all entries to w as a JSON array of {"key":...,"value":...}
+// objects, one entry at a time. The output can be read back by Import.
+func (storage *Storage[T]) Export(w io.Writer) error {
+	unlock := storage.rlockAll()
+	defer unlock()
+
+	names, err := storage.names()
+	if err != nil {
+		return fmt.Errorf("%w: failed to export JSONs: %s", ErrInternal, err)
+	}
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return fmt.Errorf("%w: failed to export JSONs: %s", ErrInternal, err)
+	}
+
+	first := true
+	write := func(key string, value T) error {
+		b, err := json.Marshal(entry[T]{Key: key, Value: value})
+		if err != nil {
+			return fmt.Errorf("%w: failed to export JSONs: %s", ErrInternal, err)
+		}
+
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return fmt.Errorf("%w: failed to export JSONs: %s", ErrInternal, err)
+			}
+		}
+		first = false
+
+		if _, err := w.Write(b); err != nil {
+			return fmt.Errorf("%w: failed to export JSONs: %s", ErrInternal, err)
+		}
+
+		return nil
+	}
+
+	if err := storage.rangeNames(context.Background(), names, write); err != nil {
+		return err
+	}
+	if err := storage.rangeFallbacks(context.Background(), names, write); err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, "]\n"); err != nil {
+		return fmt.Errorf("%w: failed to export JSONs: %s", ErrInternal, err)
+	}
+
+	return nil
+}
+
+// ExportJSONL writes all entries to w as JSON Lines, one {"key":...,"value":...}
+// object per line, one entry at a time.
+func (storage *Storage[T]) ExportJSONL(w io.Writer) error {
+	unlock := storage.rlockAll()
+	defer unlock()
+
+	names, err := storage.names()
+	if err != nil {
+		return fmt.Errorf("%w: failed to export JSONs: %s", ErrInternal, err)
+	}
+
+	write := func(key string, value T) error {
+		b, err := json.Marshal(entry[T]{Key: key, Value: value})
+		if err != nil {
+			return fmt.Errorf("%w: failed to export JSONs: %s", ErrInternal, err)
+		}
+
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			return fmt.Errorf("%w: failed to export JSONs: %s", ErrInternal, err)
+		}
+
+		return nil
+	}
+
+	if err := storage.rangeNames(context.Background(), names, write); err != nil {
+		return err
+	}
+
+	return storage.rangeFallbacks(context.Background(), names, write)
+}
+
+// Dump returns the entries in the storage sorted by key as "key: value" lines,
+// the values indented as JSON. It is meant for debugging, e.g. in test logs.
+func (storage *Storage[T]) Dump() (string, error) {
+	unlock := storage.rlockAll()
+	defer unlock()
+
+	names, err := storage.names()
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to dump JSONs: %s", ErrInternal, err)
+	}
+
+	entries := []entry[T]{}
+	collect := func(key string, value T) error {
+		entries = append(entries, entry[T]{Key: key, Value: value})
+		return nil
+	}
+
+	if err := storage.rangeNames(context.Background(), names, collect); err != nil {
+		return "", err
+	}
+	if err := storage.rangeFallbacks(context.Background(), names, collect); err != nil {
+		return "", err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+
+	sb := strings.Builder{}
+	for _, ent := range entries {
+		b, err := json.MarshalIndent(ent.Value, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("%w: failed to dump JSONs: %s: %s", ErrInternal, ent.Key, err)
+		}
+		sb.WriteString(ent.Key)
+		sb.WriteString(": ")
+		sb.Write(b)
+		sb.WriteString("\n")
+	}
+
+	return sb.String(), nil
+}
+
+// Import reads a JSON array written by Export from r and stores each entry.
+// Existing keys are overwritten if overwrite is true and left as is otherwise.
+func (storage *Storage[T]) Import(r io.Reader, overwrite bool) error {
+	if err := storage.writable(); err != nil {
+		return err
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	dec := storage.newDecoder(r)
+
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("%w: failed to import JSONs: %s", ErrInternal, err)
+	}
+
+	for dec.More() {
+		ent := entry[T]{}
+		if err := dec.Decode(&ent); err != nil {
+			return fmt.Errorf("%w: failed to import JSONs: %s", ErrInternal, err)
+		}
+
+		if err := storage.checkKey(ent.Key); err != nil {
+			return err
+		}
+
+		path := storage.path(ent.Key)
+		if !overwrite && storage.exists(path) {
+			continue
+		}
+
+		if err := storage.validate(ent.Key, ent.Value); err != nil {
+			return err
+		}
+
+		if err := storage.beforePut(ent.Key, ent.Value); err != nil {
+			return err
+		}
+
+		if err := storage.put(path, ent); err != nil {
+			return &InternalError{Key: ent.Key, Msg: "failed to import JSONs", Err: err}
+		}
+
+		storage.afterPut(ent.Key, ent.Value)
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("%w: failed to import JSONs: %s", ErrInternal, err)
+	}
+
+	return nil
+}
+
+// ImportDir stores each bare JSON value in the ".json" files of srcDir under
+// the key decoded from its file name, and returns how many were stored. Files
+// that cannot be read or decoded are skipped and passed to the report function
+// of WithSkipCorrupt, if any.
+func (storage *Storage[T]) ImportDir(srcDir string) (int, error) {
+	if err := storage.writable(); err != nil {
+		return 0, err
+	}
+
+	direntries, err := fstools.ReadDir(srcDir)
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to import JSONs: %s", ErrInternal, err)
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	count := 0
+	for _, direntry := range direntries {
+		name := direntry.Name()
+		if direntry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		key, err := storage.decodeKey(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			key = strings.TrimSuffix(name, ".json")
+		}
+		if storage.checkKey(key) != nil {
+			continue
+		}
+
+		srcPath := filepath.Join(srcDir, name)
+
+		value := *new(T)
+		err = fstools.ReadFileFunc(srcPath, func(r io.Reader) error {
+			return storage.newDecoder(r).Decode(&value)
+		})
+		if err != nil {
+			if storage.onCorrupt != nil {
+				storage.onCorrupt(key, srcPath, err)
+			}
+			continue
+		}
+
+		if err := storage.validate(key, value); err != nil {
+			return count, err
+		}
+
+		if err := storage.beforePut(key, value); err != nil {
+			return count, err
+		}
+
+		if err := storage.put(storage.path(key), entry[T]{Key: key, Value: value}); err != nil {
+			return count, &InternalError{Key: key, Msg: "failed to import JSON", Err: err}
+		}
+
+		storage.afterPut(key, value)
+
+		count++
+	}
+
+	return count, nil
+}
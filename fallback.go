@@ -0,0 +1,138 @@
+package jsonstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// fallbackGet reads the entry of key from the first fallback holding it.
+func (storage *Storage[T]) fallbackGet(key string) (entry[T], error) {
+	for _, fallback := range storage.fallbacks {
+		ent, err := fallback.get(fallback.path(key))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return entry[T]{}, err
+		}
+		return ent, nil
+	}
+	return entry[T]{}, os.ErrNotExist
+}
+
+// fallbackGetRaw is fallbackGet for GetRaw.
+func (storage *Storage[T]) fallbackGetRaw(key string) (entry[json.RawMessage], error) {
+	for _, fallback := range storage.fallbacks {
+		ent, err := fallback.getRaw(fallback.path(key))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return entry[json.RawMessage]{}, err
+		}
+		return ent, nil
+	}
+	return entry[json.RawMessage]{}, os.ErrNotExist
+}
+
+// fallbackHas reports whether any fallback holds key.
+func (storage *Storage[T]) fallbackHas(key string) (bool, error) {
+	for _, fallback := range storage.fallbacks {
+		if _, err := os.Stat(fallback.path(key)); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// fallbackNames returns, for each fallback, the names it holds that are not
+// shadowed by names of the primary directory or of an earlier fallback.
+func (storage *Storage[T]) fallbackNames(names []string) ([][]string, error) {
+	seen := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		seen[name] = struct{}{}
+	}
+
+	layers := make([][]string, len(storage.fallbacks))
+	for i, fallback := range storage.fallbacks {
+		fallbackNames, err := fallback.names()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range fallbackNames {
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			layers[i] = append(layers[i], name)
+		}
+	}
+
+	return layers, nil
+}
+
+// rangeFallbacks calls f for the entries of the fallbacks not shadowed by
+// names of the primary directory.
+func (storage *Storage[T]) rangeFallbacks(ctx context.Context, names []string, f func(string, T) error) error {
+	layers, err := storage.fallbackNames(names)
+	if err != nil {
+		return fmt.Errorf("%w: failed to range JSONs: %s", ErrInternal, err)
+	}
+
+	for i, fallback := range storage.fallbacks {
+		if err := fallback.rangeNames(ctx, layers[i], f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// layered is a JSON of the storage or of one of its fallbacks, by its name
+// relative to the directory of that storage.
+type layered[T any] struct {
+	storage *Storage[T]
+	name    string
+}
+
+func (l layered[T]) path() string {
+	return filepath.Join(l.storage.dir, l.name)
+}
+
+// layeredNames returns names followed by the names of the fallbacks not
+// shadowed by them, along with the storage each belongs to.
+func (storage *Storage[T]) layeredNames(names []string) ([]layered[T], error) {
+	layers, err := storage.fallbackNames(names)
+	if err != nil {
+		return nil, err
+	}
+
+	all := make([]layered[T], 0, len(names))
+	for _, name := range names {
+		all = append(all, layered[T]{storage: storage, name: name})
+	}
+	for i, fallback := range storage.fallbacks {
+		for _, name := range layers[i] {
+			all = append(all, layered[T]{storage: fallback, name: name})
+		}
+	}
+
+	return all, nil
+}
+
+// sortLayered sorts the names returned by layeredNames by their file names.
+func sortLayered[T any](all []layered[T]) {
+	sort.SliceStable(all, func(i, j int) bool {
+		return filepath.Base(all[i].name) < filepath.Base(all[j].name)
+	})
+}
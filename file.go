@@ -0,0 +1,95 @@
+package jsonstorage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/thamaji/fstools"
+)
+
+// writeFile writes the file at path atomically with fstools.WriteFileFunc,
+// applying the configured file and directory modes. fstools syncs the file
+// before renaming it; with WithSync the directory is synced after the rename.
+func (storage *Storage[T]) writeFile(path string, f func(io.Writer) error) error {
+	if err := storage.mkdirAll(filepath.Dir(path)); err != nil {
+		return storage.dirIsFile(err)
+	}
+
+	err := fstools.WriteFileFunc(path, func(w io.Writer) error {
+		if file, ok := w.(*os.File); ok && storage.fileMode != 0 {
+			if err := file.Chmod(storage.fileMode); err != nil {
+				return err
+			}
+		}
+		return f(w)
+	})
+	if err != nil {
+		return storage.dirIsFile(err)
+	}
+
+	if err := storage.removeTombstone(path); err != nil {
+		return err
+	}
+
+	if storage.sync {
+		return syncDir(filepath.Dir(path))
+	}
+
+	return nil
+}
+
+// syncDir fsyncs dir so that renames into it are durable.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if err1 := file.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// mkdirAll creates dir and its missing parents with the configured directory
+// mode, chmod-ing each one so the mode is not narrowed by the umask. Without
+// a configured mode, it is os.MkdirAll with 0755, the mode fstools uses.
+func (storage *Storage[T]) mkdirAll(dir string) error {
+	if storage.dirMode == 0 {
+		return os.MkdirAll(dir, 0755)
+	}
+
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := storage.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Mkdir(dir, storage.dirMode); err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	return os.Chmod(dir, storage.dirMode)
+}
+
+// dirIsFile replaces err with a clear message if the storage directory turns
+// out to be a regular file, which otherwise surfaces as an opaque ENOTDIR.
+func (storage *Storage[T]) dirIsFile(err error) error {
+	if info, statErr := os.Stat(storage.dir); statErr == nil && !info.IsDir() {
+		return fmt.Errorf("storage dir is a file: %s", storage.dir)
+	}
+	return err
+}
@@ -0,0 +1,35 @@
+package jsonstorage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStorageDirIsFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(dir, []byte("not a directory"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	storage := New[int](dir)
+
+	check := func(op string, err error) {
+		t.Helper()
+		if !errors.Is(err, ErrInternal) {
+			t.Errorf("%s = %v, want ErrInternal", op, err)
+		}
+		if err == nil || !strings.Contains(err.Error(), "storage dir is a file: "+dir) {
+			t.Errorf("%s = %v, want it to report that the storage dir is a file", op, err)
+		}
+	}
+
+	_, err := storage.Get("key")
+	check("Get", err)
+
+	check("Put", storage.Put("key", 1))
+
+	check("Range", storage.Range(func(string, int) error { return nil }))
+}
@@ -0,0 +1,104 @@
+package jsonstorage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// errNoWrapper explains a file decoded without a key: without WithFlatFormat
+// every file holds the key along with the value.
+var errNoWrapper = fmt.Errorf("%w: no key in file, written with WithFlatFormat?", ErrDecode)
+
+// errWrapper explains a file decoded with WithFlatFormat that holds a key and
+// metadata around the value, as written without WithFlatFormat.
+var errWrapper = fmt.Errorf("%w: key in file, written without WithFlatFormat?", ErrDecode)
+
+// wrapperFields are the JSON names of the fields of entry and entryMeta.
+var wrapperFields = map[string]bool{
+	"key":            true,
+	"value":          true,
+	"expires_at":     true,
+	"schema_version": true,
+	"blob":           true,
+	"version":        true,
+	"created_at":     true,
+	"updated_at":     true,
+	"checksum":       true,
+}
+
+// decodeFlat reads a file written with WithFlatFormat from r into v and
+// returns the key, taken from the file name at path. With the default JSON
+// codec, a file that looks like an entry, an object with only the fields of
+// an entry and the key of path, fails with errWrapper.
+func (storage *Storage[T]) decodeFlat(r io.Reader, path string, v any) (string, error) {
+	if _, ok := storage.codec.(jsonCodec); ok {
+		raw := json.RawMessage{}
+		if err := storage.decode(r, &raw); err != nil {
+			return "", err
+		}
+		if storage.isWrapper(raw, path) {
+			return "", errWrapper
+		}
+		if err := storage.newDecoder(bytes.NewReader(raw)).Decode(v); err != nil {
+			return "", fmt.Errorf("%w: %w", ErrDecode, err)
+		}
+	} else if err := storage.decode(r, v); err != nil {
+		return "", err
+	}
+
+	name, err := filepath.Rel(storage.dir, path)
+	if err != nil {
+		return "", err
+	}
+
+	return storage.key(name)
+}
+
+// isWrapper reports whether raw, read from the file at path, is an entry as
+// written without WithFlatFormat.
+func (storage *Storage[T]) isWrapper(raw json.RawMessage, path string) bool {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return false
+	}
+
+	if _, ok := fields["value"]; !ok {
+		if _, ok := fields["blob"]; !ok {
+			return false
+		}
+	}
+
+	for name := range fields {
+		if !wrapperFields[name] {
+			return false
+		}
+	}
+
+	key := ""
+	if err := json.Unmarshal(fields["key"], &key); err != nil {
+		return false
+	}
+
+	return validateKey(key) == nil && storage.path(key) == path
+}
+
+// checkFlat fails with ErrInvalidValue if raw, the value of key to be written
+// to the file at path with WithFlatFormat, would be read back as an entry.
+func (storage *Storage[T]) checkFlat(key string, path string, raw json.RawMessage) error {
+	if storage.isWrapper(raw, path) {
+		return fmt.Errorf("%w: value looks like an entry in the flat format: %s", ErrInvalidValue, key)
+	}
+	return nil
+}
+
+// stored returns what is written to the file of ent: the entry, or only its
+// value with WithFlatFormat.
+func (storage *Storage[T]) stored(ent entry[T]) any {
+	if storage.flat {
+		return ent.Value
+	}
+	return ent
+}
@@ -1,5 +1,10 @@
 module github.com/thamaji/jsonstorage
 
-go 1.18
+go 1.23
 
-require github.com/thamaji/fstools v1.0.0 // indirect
+require (
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/thamaji/fstools v1.0.0
+)
+
+require golang.org/x/sys v0.13.0 // indirect
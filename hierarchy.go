@@ -0,0 +1,60 @@
+package jsonstorage
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// splitKey maps a key to the directories and file name it is stored under
+// with WithHierarchicalKeys, escaping each "/"-separated segment on its own.
+// Empty segments are dropped, and "." and ".." are escaped like a Scope
+// namespace, so that no key resolves outside the storage directory. With
+// WithDedup a leading "blobs" directory is escaped as well, keeping the keys
+// out of the blob directory.
+func (storage *Storage[T]) splitKey(key string) (string, string) {
+	segments := []string{}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" {
+			continue
+		}
+		segment = storage.encodeKey(segment)
+		if segment == "." || segment == ".." {
+			segment = strings.ReplaceAll(segment, ".", "%2E")
+		}
+		segments = append(segments, segment)
+	}
+
+	if len(segments) == 0 {
+		return "", storage.encodeKey(key)
+	}
+
+	if storage.dedup && len(segments) > 1 && segments[0] == blobDir {
+		segments[0] = "%62" + blobDir[1:]
+	}
+
+	return filepath.Join(segments[:len(segments)-1]...), segments[len(segments)-1]
+}
+
+// joinKey reverses splitKey for a path relative to the storage directory,
+// without the extension.
+func (storage *Storage[T]) joinKey(name string) (string, error) {
+	segments := strings.Split(filepath.ToSlash(name), "/")
+	for i, segment := range segments {
+		key, err := storage.decodeKey(segment)
+		if err != nil {
+			return "", err
+		}
+		segments[i] = key
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// walkDepth is the depth of the shard directories walked down to the JSONs.
+// Hierarchical keys are never sharded, and their directories are walked to
+// any depth.
+func (storage *Storage[T]) walkDepth() int {
+	if storage.hierarchical {
+		return 0
+	}
+	return storage.shardDepth
+}
@@ -0,0 +1,32 @@
+package jsonstorage
+
+// The hooks run while the storage lock of the operation is held, so they see
+// mutations in the order they are applied. They must not call back into the
+// storage. Entries removed on expiry or by WithMaxEntries, and files brought
+// back as they are by Undelete or Restore, do not run the hooks.
+
+func (storage *Storage[T]) beforePut(key string, value T) error {
+	if storage.beforePutHook == nil {
+		return nil
+	}
+	return storage.beforePutHook(key, value)
+}
+
+func (storage *Storage[T]) afterPut(key string, value T) {
+	if storage.afterPutHook != nil {
+		storage.afterPutHook(key, value)
+	}
+}
+
+func (storage *Storage[T]) beforeDelete(key string) error {
+	if storage.beforeDeleteHook == nil {
+		return nil
+	}
+	return storage.beforeDeleteHook(key)
+}
+
+func (storage *Storage[T]) afterDelete(key string) {
+	if storage.afterDeleteHook != nil {
+		storage.afterDeleteHook(key)
+	}
+}
@@ -0,0 +1,62 @@
+package jsonstorage
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// Without stripes every operation serializes on storage.mutex. With stripes,
+// single-key operations share storage.mutex and lock the stripe of their path,
+// so operations on different keys run in parallel. Operations spanning the
+// whole storage lock storage.mutex exclusively, or every stripe for reading.
+// With WithMaxEntries a write may evict other keys, so single-key writes lock
+// storage.mutex exclusively as well.
+
+func (storage *Storage[T]) lockKey(path string) func() {
+	if storage.stripes == nil || storage.maxEntries > 0 {
+		storage.mutex.Lock()
+		return storage.mutex.Unlock
+	}
+
+	stripe := storage.stripe(path)
+	storage.mutex.RLock()
+	stripe.Lock()
+	return func() {
+		stripe.Unlock()
+		storage.mutex.RUnlock()
+	}
+}
+
+func (storage *Storage[T]) rlockKey(path string) func() {
+	if storage.stripes == nil {
+		storage.mutex.RLock()
+		return storage.mutex.RUnlock
+	}
+
+	stripe := storage.stripe(path)
+	storage.mutex.RLock()
+	stripe.RLock()
+	return func() {
+		stripe.RUnlock()
+		storage.mutex.RUnlock()
+	}
+}
+
+func (storage *Storage[T]) rlockAll() func() {
+	storage.mutex.RLock()
+	for i := range storage.stripes {
+		storage.stripes[i].RLock()
+	}
+	return func() {
+		for i := range storage.stripes {
+			storage.stripes[i].RUnlock()
+		}
+		storage.mutex.RUnlock()
+	}
+}
+
+func (storage *Storage[T]) stripe(path string) *sync.RWMutex {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	return &storage.stripes[h.Sum32()%uint32(len(storage.stripes))]
+}
@@ -0,0 +1,143 @@
+package jsonstorage
+
+import "sync"
+
+// NewMemory returns a Store that keeps entries in memory. Of opts, only the
+// ones affecting keys are applied, so keys are normalized the same way as New.
+// Like Storage, it rejects empty or whitespace-only keys with ErrInvalidKey.
+func NewMemory[T any](opts ...Option[T]) *MemoryStorage[T] {
+	return &MemoryStorage[T]{
+		normalize: New[T]("", opts...).normalize,
+		entries:   map[string]entry[T]{},
+		mutex:     sync.RWMutex{},
+	}
+}
+
+type MemoryStorage[T any] struct {
+	normalize func(string) string
+	entries   map[string]entry[T]
+	mutex     sync.RWMutex
+}
+
+var _ Store[any] = (*MemoryStorage[any])(nil)
+
+func (storage *MemoryStorage[T]) Range(f func(string, T) error) error {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	for _, ent := range storage.entries {
+		if err := f(ent.Key, ent.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (storage *MemoryStorage[T]) Keys() ([]string, error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	keys := make([]string, 0, len(storage.entries))
+	for key := range storage.entries {
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (storage *MemoryStorage[T]) Get(key string) (T, error) {
+	if err := validateKey(key); err != nil {
+		return *new(T), err
+	}
+
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	ent, ok := storage.entries[storage.normalize(key)]
+	if !ok {
+		return *new(T), &NotExistError{Key: key}
+	}
+
+	return ent.Value, nil
+}
+
+func (storage *MemoryStorage[T]) Has(key string) (bool, error) {
+	if err := validateKey(key); err != nil {
+		return false, err
+	}
+
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	_, ok := storage.entries[storage.normalize(key)]
+
+	return ok, nil
+}
+
+func (storage *MemoryStorage[T]) Put(key string, value T) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	storage.entries[storage.normalize(key)] = entry[T]{Key: key, Value: value}
+
+	return nil
+}
+
+func (storage *MemoryStorage[T]) Edit(key string, f func(T) (T, error)) (T, error) {
+	if err := validateKey(key); err != nil {
+		return *new(T), err
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	ent, ok := storage.entries[storage.normalize(key)]
+	if !ok {
+		return *new(T), &NotExistError{Key: key}
+	}
+
+	value, err := f(ent.Value)
+	if err != nil {
+		return *new(T), err
+	}
+
+	storage.entries[storage.normalize(key)] = entry[T]{Key: key, Value: value}
+
+	return value, nil
+}
+
+func (storage *MemoryStorage[T]) Update(key string, f func(T) (T, error)) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	value, err := f(storage.entries[storage.normalize(key)].Value)
+	if err != nil {
+		return err
+	}
+
+	storage.entries[storage.normalize(key)] = entry[T]{Key: key, Value: value}
+
+	return nil
+}
+
+func (storage *MemoryStorage[T]) Delete(key string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	delete(storage.entries, storage.normalize(key))
+
+	return nil
+}
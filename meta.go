@@ -0,0 +1,133 @@
+package jsonstorage
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/thamaji/fstools"
+)
+
+// Meta describes an entry besides its value.
+type Meta struct {
+	// CreatedAt is when the key was first put.
+	CreatedAt time.Time
+	// UpdatedAt is when the value was last put.
+	UpdatedAt time.Time
+	// ExpiresAt is when the entry expires, or the zero time if it never does.
+	ExpiresAt time.Time
+	// Version is incremented by each write, see GetVersioned.
+	Version uint64
+}
+
+// GetMeta is like Get but also returns the metadata of the entry. JSONs
+// written before entries were timestamped report their modification time as
+// CreatedAt and UpdatedAt.
+func (storage *Storage[T]) GetMeta(key string) (T, Meta, error) {
+	if err := storage.checkKey(key); err != nil {
+		return *new(T), Meta{}, err
+	}
+
+	path := storage.path(key)
+
+	unlock := storage.rlockKey(path)
+	defer unlock()
+
+	ent, err := storage.get(path)
+	if errors.Is(err, os.ErrNotExist) && len(storage.fallbacks) > 0 {
+		ent, err = storage.fallbackGet(key)
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return *new(T), Meta{}, &NotExistError{Key: key}
+		}
+		return *new(T), Meta{}, &InternalError{Key: key, Msg: "failed to get JSON", Err: err}
+	}
+
+	meta := Meta{Version: ent.Version}
+	if ent.CreatedAt != nil {
+		meta.CreatedAt = *ent.CreatedAt
+	}
+	if ent.UpdatedAt != nil {
+		meta.UpdatedAt = *ent.UpdatedAt
+	}
+	if ent.ExpiresAt != nil {
+		meta.ExpiresAt = *ent.ExpiresAt
+	}
+
+	return ent.Value, meta, nil
+}
+
+// meta returns the metadata of the entry at path without decoding its value.
+// It returns os.ErrNotExist if there is no entry or it has expired.
+func (storage *Storage[T]) meta(path string) (entryMeta, error) {
+	if b, ok := storage.buffered(path); ok {
+		if b.deleted {
+			return entryMeta{}, os.ErrNotExist
+		}
+		if b.ent.ExpiresAt != nil && !storage.now().Before(*b.ent.ExpiresAt) {
+			return entryMeta{}, os.ErrNotExist
+		}
+		return b.ent.entryMeta, nil
+	}
+
+	if ent, ok := storage.cache.get(path); ok {
+		if ent.ExpiresAt != nil && !storage.now().Before(*ent.ExpiresAt) {
+			return entryMeta{}, os.ErrNotExist
+		}
+		return ent.entryMeta, nil
+	}
+
+	if storage.flat {
+		return entryMeta{}, nil
+	}
+
+	// The value is left raw so that WithDecoderOptions does not reject it.
+	ent := entry[json.RawMessage]{}
+	err := fstools.ReadFileFunc(path, func(r io.Reader) error {
+		if err := storage.decode(r, &ent); err != nil {
+			return err
+		}
+		return backfill(r, &ent.entryMeta)
+	})
+	meta := ent.entryMeta
+	if err != nil {
+		return entryMeta{}, err
+	}
+
+	if meta.ExpiresAt != nil && !storage.now().Before(*meta.ExpiresAt) {
+		return entryMeta{}, os.ErrNotExist
+	}
+
+	return meta, nil
+}
+
+// backfill sets the timestamps missing from meta to the modification time of
+// the file being read from r.
+func backfill(r io.Reader, meta *entryMeta) error {
+	if meta.CreatedAt != nil && meta.UpdatedAt != nil {
+		return nil
+	}
+
+	file, ok := r.(*os.File)
+	if !ok {
+		return nil
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	modTime := info.ModTime()
+	if meta.CreatedAt == nil {
+		meta.CreatedAt = &modTime
+	}
+	if meta.UpdatedAt == nil {
+		meta.UpdatedAt = &modTime
+	}
+
+	return nil
+}
@@ -0,0 +1,290 @@
+package jsonstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Rename moves the entry of oldKey to newKey, updating its stored key. If
+// newKey already exists, it is replaced when overwrite is true and
+// ErrAlreadyExists is returned otherwise. The delete hooks run for oldKey and
+// the put hooks for newKey.
+func (storage *Storage[T]) Rename(oldKey, newKey string, overwrite bool) error {
+	if err := storage.writable(); err != nil {
+		return err
+	}
+
+	if err := storage.checkKey(oldKey); err != nil {
+		return err
+	}
+	if err := storage.checkKey(newKey); err != nil {
+		return err
+	}
+
+	oldPath, newPath := storage.path(oldKey), storage.path(newKey)
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	// The file is moved on disk, so pending writes must be there first.
+	if err := storage.flush(); err != nil {
+		return err
+	}
+
+	ent, err := storage.get(oldPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &NotExistError{Key: oldKey}
+		}
+		return &InternalError{Key: oldKey, Msg: "failed to rename JSON", Err: err}
+	}
+
+	same := oldPath == newPath
+	if !same && !overwrite && storage.exists(newPath) {
+		return fmt.Errorf("%w: %s", ErrAlreadyExists, newKey)
+	}
+
+	if err := storage.validate(newKey, ent.Value); err != nil {
+		return err
+	}
+
+	if !same {
+		if err := storage.beforeDelete(oldKey); err != nil {
+			return err
+		}
+	}
+
+	if err := storage.beforePut(newKey, ent.Value); err != nil {
+		return err
+	}
+
+	// The file is moved on disk below, so the re-keyed entry must not go to
+	// the write buffer.
+	ent.Key = newKey
+	ent, err = storage.stamp(oldPath, ent)
+	if err != nil {
+		return &InternalError{Key: oldKey, Msg: "failed to rename JSON", Err: err}
+	}
+	if err := storage.putFile(oldPath, ent); err != nil {
+		return &InternalError{Key: oldKey, Msg: "failed to rename JSON", Err: err}
+	}
+
+	if same {
+		storage.afterPut(newKey, ent.Value)
+		return nil
+	}
+
+	storage.cache.remove(oldPath)
+	storage.cache.remove(newPath)
+
+	if err := storage.mkdirAll(filepath.Dir(newPath)); err != nil {
+		return &InternalError{Key: newKey, Msg: "failed to rename JSON", Err: err}
+	}
+
+	if storage.dedup {
+		if err := storage.unlink(newPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return &InternalError{Key: newKey, Msg: "failed to rename JSON", Err: err}
+		}
+	}
+
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return &InternalError{Key: newKey, Msg: "failed to rename JSON", Err: err}
+	}
+
+	if storage.sync {
+		if err := syncDir(filepath.Dir(newPath)); err != nil {
+			return &InternalError{Key: newKey, Msg: "failed to rename JSON", Err: err}
+		}
+	}
+
+	storage.afterDelete(oldKey)
+	storage.afterPut(newKey, ent.Value)
+
+	return nil
+}
+
+// Copy stores the value of srcKey under dstKey as well. If dstKey already
+// exists, it is replaced when overwrite is true and ErrAlreadyExists is
+// returned otherwise.
+func (storage *Storage[T]) Copy(srcKey, dstKey string, overwrite bool) error {
+	if err := storage.writable(); err != nil {
+		return err
+	}
+
+	if err := storage.checkKey(srcKey); err != nil {
+		return err
+	}
+	if err := storage.checkKey(dstKey); err != nil {
+		return err
+	}
+
+	srcPath, dstPath := storage.path(srcKey), storage.path(dstKey)
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	ent, err := storage.get(srcPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &NotExistError{Key: srcKey}
+		}
+		return &InternalError{Key: srcKey, Msg: "failed to copy JSON", Err: err}
+	}
+
+	if !overwrite && storage.exists(dstPath) {
+		return fmt.Errorf("%w: %s", ErrAlreadyExists, dstKey)
+	}
+
+	if err := storage.validate(dstKey, ent.Value); err != nil {
+		return err
+	}
+
+	if err := storage.beforePut(dstKey, ent.Value); err != nil {
+		return err
+	}
+
+	ent.Key = dstKey
+	if err := storage.put(dstPath, ent); err != nil {
+		return &InternalError{Key: dstKey, Msg: "failed to copy JSON", Err: err}
+	}
+
+	storage.afterPut(dstKey, ent.Value)
+
+	return nil
+}
+
+// Swap exchanges the values of keyA and keyB under the write lock, so no
+// other operation of the storage sees one written without the other. Each key
+// keeps its own expiry. If the second write fails, the first is undone.
+func (storage *Storage[T]) Swap(keyA, keyB string) error {
+	if err := storage.writable(); err != nil {
+		return err
+	}
+
+	if err := storage.checkKey(keyA); err != nil {
+		return err
+	}
+	if err := storage.checkKey(keyB); err != nil {
+		return err
+	}
+
+	pathA, pathB := storage.path(keyA), storage.path(keyB)
+	if pathA == pathB {
+		return nil
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	entA, err := storage.get(pathA)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &NotExistError{Key: keyA}
+		}
+		return &InternalError{Key: keyA, Msg: "failed to swap JSON", Err: err}
+	}
+
+	entB, err := storage.get(pathB)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &NotExistError{Key: keyB}
+		}
+		return &InternalError{Key: keyB, Msg: "failed to swap JSON", Err: err}
+	}
+
+	if err := storage.validate(keyA, entB.Value); err != nil {
+		return err
+	}
+	if err := storage.validate(keyB, entA.Value); err != nil {
+		return err
+	}
+
+	if err := storage.beforePut(keyA, entB.Value); err != nil {
+		return err
+	}
+	if err := storage.beforePut(keyB, entA.Value); err != nil {
+		return err
+	}
+
+	if err := storage.put(pathA, entry[T]{Key: entA.Key, Value: entB.Value, entryMeta: entA.entryMeta}); err != nil {
+		return &InternalError{Key: keyA, Msg: "failed to swap JSON", Err: err}
+	}
+
+	if err := storage.put(pathB, entry[T]{Key: entB.Key, Value: entA.Value, entryMeta: entB.entryMeta}); err != nil {
+		storage.put(pathA, entA)
+		return &InternalError{Key: keyB, Msg: "failed to swap JSON", Err: err}
+	}
+
+	storage.afterPut(keyA, entB.Value)
+	storage.afterPut(keyB, entA.Value)
+
+	return nil
+}
+
+// Merge stores every entry of other in this storage. For keys present in both,
+// the stored value is the result of onConflict, or the incoming value if
+// onConflict is nil. This storage is locked for writing before other is locked
+// for reading, so two storages must not be merged into each other concurrently.
+// Merging a storage into itself does nothing.
+func (storage *Storage[T]) Merge(other *Storage[T], onConflict func(key string, existing, incoming T) (T, error)) error {
+	if err := storage.writable(); err != nil {
+		return err
+	}
+
+	if other == storage {
+		return nil
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	unlock := other.rlockAll()
+	defer unlock()
+
+	names, err := other.names()
+	if err != nil {
+		return fmt.Errorf("%w: failed to merge JSONs: %s", ErrInternal, err)
+	}
+
+	merge := func(key string, incoming T) error {
+		path := storage.path(key)
+
+		value := incoming
+		if onConflict != nil {
+			ent, err := storage.get(path)
+			if err == nil {
+				value, err = onConflict(key, ent.Value, incoming)
+				if err != nil {
+					return err
+				}
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return &InternalError{Key: key, Msg: "failed to merge JSON", Err: err}
+			}
+		}
+
+		if err := storage.validate(key, value); err != nil {
+			return err
+		}
+
+		if err := storage.beforePut(key, value); err != nil {
+			return err
+		}
+
+		if err := storage.put(path, entry[T]{Key: key, Value: value}); err != nil {
+			return &InternalError{Key: key, Msg: "failed to merge JSON", Err: err}
+		}
+
+		storage.afterPut(key, value)
+
+		return nil
+	}
+
+	if err := other.rangeNames(context.Background(), names, merge); err != nil {
+		return err
+	}
+
+	return other.rangeFallbacks(context.Background(), names, merge)
+}
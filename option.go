@@ -0,0 +1,405 @@
+package jsonstorage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Option configures a Storage created by New.
+type Option[T any] func(*Storage[T])
+
+func WithCodec[T any](codec Codec) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.codec = codec
+	}
+}
+
+// WithCompression stores the files gzip-compressed, appending ".gz" to the
+// file extension.
+func WithCompression[T any]() Option[T] {
+	return func(storage *Storage[T]) {
+		storage.compress = true
+	}
+}
+
+// WithIndent formats the files written by the default JSON codec with
+// json.Encoder.SetIndent.
+func WithIndent[T any](prefix, indent string) Option[T] {
+	return func(storage *Storage[T]) {
+		if codec, ok := storage.codec.(jsonCodec); ok {
+			codec.prefix, codec.indent = prefix, indent
+			storage.codec = codec
+		}
+	}
+}
+
+// WithExtension sets the file extension used instead of the one derived from
+// the codec. It panics if ext does not start with a dot.
+func WithExtension[T any](ext string) Option[T] {
+	if !strings.HasPrefix(ext, ".") || len(ext) < 2 {
+		panic("jsonstorage: extension must start with a dot: " + ext)
+	}
+	return func(storage *Storage[T]) {
+		storage.ext = ext
+	}
+}
+
+// WithCaseSensitive keeps keys as given instead of lowercasing them.
+func WithCaseSensitive[T any]() Option[T] {
+	return func(storage *Storage[T]) {
+		storage.normalizeKey = func(key string) string { return key }
+	}
+}
+
+// WithSkipCorrupt makes Range skip JSONs that cannot be read or decoded
+// instead of aborting. If report is not nil, it is called with the key
+// derived from the file name, the path and the error of each skipped JSON,
+// including the files skipped by ImportDir.
+func WithSkipCorrupt[T any](report func(key string, path string, err error)) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.skipCorrupt = true
+		storage.onCorrupt = report
+	}
+}
+
+// WithStrictGetAll makes GetAll fail with ErrNotExist when any key is missing.
+func WithStrictGetAll[T any]() Option[T] {
+	return func(storage *Storage[T]) {
+		storage.strictGetAll = true
+	}
+}
+
+// WithCache keeps up to maxEntries decoded values in memory and serves reads
+// from them. Cached values are shared between callers, so values holding maps,
+// slices or pointers must not be mutated.
+func WithCache[T any](maxEntries int) Option[T] {
+	return func(storage *Storage[T]) {
+		if maxEntries > 0 {
+			storage.cache = newCache[T](maxEntries)
+		}
+	}
+}
+
+// WithStripedLock lets operations on different keys run in parallel by
+// guarding each key with one of n locks selected by hashing its path.
+func WithStripedLock[T any](n int) Option[T] {
+	return func(storage *Storage[T]) {
+		if n > 0 {
+			storage.stripes = make([]sync.RWMutex, n)
+		}
+	}
+}
+
+// WithObserver calls observer after each Get, Put, Delete and Range with the
+// operation name ("get", "put", "delete" or "range"), the key, the resulting
+// error and the elapsed time.
+func WithObserver[T any](observer func(op string, key string, err error, dur time.Duration)) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.observer = observer
+	}
+}
+
+// WithLogger calls logger at debug level with the key and path touched by Get,
+// Put, Delete and Range. The args are slog-style key-value pairs.
+func WithLogger[T any](logger func(ctx context.Context, msg string, args ...any)) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.logger = logger
+	}
+}
+
+// WithClock replaces time.Now as the source of the current time for TTLs.
+func WithClock[T any](now func() time.Time) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.now = now
+	}
+}
+
+// WithEncryption encrypts the files with AES-GCM using key, which must be 16,
+// 24 or 32 bytes long. It panics if key has an invalid length.
+func WithEncryption[T any](key []byte) Option[T] {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic("jsonstorage: invalid encryption key: " + err.Error())
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic("jsonstorage: invalid encryption key: " + err.Error())
+	}
+	return func(storage *Storage[T]) {
+		storage.aead = aead
+	}
+}
+
+// WithMaxNameLength sets the length in bytes beyond which a file name is
+// replaced with the SHA-256 of the key. The default is 255.
+func WithMaxNameLength[T any](n int) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.maxNameLength = n
+	}
+}
+
+// WithSharding spreads the files over depth levels of subdirectories named
+// after the leading bytes of the SHA-256 of each file name, e.g. "ab/cd" for a
+// depth of 2. depth is capped at 32.
+func WithSharding[T any](depth int) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.shardDepth = min(max(depth, 0), 32)
+	}
+}
+
+// WithKeyEncoder replaces url.PathEscape and url.PathUnescape in mapping keys
+// to file names and back. encode must produce distinct, non-empty file names
+// for distinct keys, none of them starting with '#', which marks hashed keys.
+func WithKeyEncoder[T any](encode func(string) string, decode func(string) (string, error)) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.encodeKey = encode
+		storage.decodeKey = decode
+	}
+}
+
+// WithFileMode sets the permission bits of the files, 0644 by default.
+func WithFileMode[T any](mode os.FileMode) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.fileMode = mode.Perm()
+	}
+}
+
+// WithDirMode sets the permission bits of the directories created by the
+// storage, 0755 by default.
+func WithDirMode[T any](mode os.FileMode) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.dirMode = mode.Perm()
+	}
+}
+
+// WithSync makes writes durable against power loss by fsyncing the directory
+// after each file is renamed into place; file contents are always fsynced.
+// This costs an extra disk flush per write, which can slow writes down
+// considerably, especially on rotational disks.
+func WithSync[T any]() Option[T] {
+	return func(storage *Storage[T]) {
+		storage.sync = true
+	}
+}
+
+// WithDisableHTMLEscape makes the default JSON codec write '<', '>' and '&'
+// as is instead of escaping them.
+func WithDisableHTMLEscape[T any]() Option[T] {
+	return func(storage *Storage[T]) {
+		if codec, ok := storage.codec.(jsonCodec); ok {
+			codec.noEscapeHTML = true
+			storage.codec = codec
+		}
+	}
+}
+
+// WithValidator makes every write of a value call validator first. If it
+// returns an error, nothing is written and the error is returned wrapped with
+// ErrInvalidValue.
+func WithValidator[T any](validator func(key string, value T) error) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.validator = validator
+	}
+}
+
+// WithStrictValidation makes PutAll validate every entry before writing any
+// of them, instead of stopping at the first invalid entry.
+func WithStrictValidation[T any]() Option[T] {
+	return func(storage *Storage[T]) {
+		storage.strictValidation = true
+	}
+}
+
+// WithBeforePut calls hook before each value is written, under the lock of
+// the write. If hook returns an error, nothing is written and the error is
+// returned.
+func WithBeforePut[T any](hook func(key string, value T) error) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.beforePutHook = hook
+	}
+}
+
+// WithAfterPut calls hook after each value is written, under the lock of the
+// write.
+func WithAfterPut[T any](hook func(key string, value T)) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.afterPutHook = hook
+	}
+}
+
+// WithBeforeDelete calls hook before each key is deleted by Delete,
+// DeleteExisting, DeleteAll, DeleteWhere, UpdateMany, Clear or Rename, under
+// the lock of the deletion. If hook returns an error, the key is not deleted
+// and the error is returned.
+func WithBeforeDelete[T any](hook func(key string) error) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.beforeDeleteHook = hook
+	}
+}
+
+// WithAfterDelete calls hook after each key is deleted by Delete,
+// DeleteExisting, DeleteAll, DeleteWhere, UpdateMany, Clear or Rename, under
+// the lock of the deletion.
+func WithAfterDelete[T any](hook func(key string)) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.afterDeleteHook = hook
+	}
+}
+
+// WithMigration sets the schema version stamped on the entries written, and
+// migrate to convert values written with an older version, given as raw JSON.
+// Files without a version are version 0. It requires the default JSON codec.
+func WithMigration[T any](version int, migrate func(version int, raw json.RawMessage) (T, error)) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.schemaVersion = version
+		storage.migrate = migrate
+	}
+}
+
+// WithKeyNormalizer replaces strings.ToLower as the function mapping keys to
+// their canonical form before they are turned into file names, e.g. to trim
+// them or to apply Unicode normalization.
+func WithKeyNormalizer[T any](normalize func(string) string) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.normalizeKey = normalize
+	}
+}
+
+// WithSoftDelete makes Delete, DeleteAll, DeleteWhere and Clear rename the
+// files to tombstones instead of removing them. Undelete brings a key back,
+// Purge removes the tombstones for good, and putting a key drops its tombstone.
+func WithSoftDelete[T any]() Option[T] {
+	return func(storage *Storage[T]) {
+		storage.softDelete = true
+	}
+}
+
+// WithRetry retries the file operations of Get, Put and Delete up to attempts
+// times in total on transient errors, waiting backoff before the first retry
+// and twice as long before each next one. The transient errors default to
+// EAGAIN, ESTALE and EINTR; pass errs to replace them. Missing entries are
+// never retried, and the context variants stop retrying when ctx is done.
+func WithRetry[T any](attempts int, backoff time.Duration, errs ...error) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.retryAttempts = attempts
+		storage.retryBackoff = backoff
+		storage.retryErrors = transientErrors
+		if len(errs) > 0 {
+			storage.retryErrors = errs
+		}
+	}
+}
+
+// WithDedup stores each distinct value once as a blob in the "blobs"
+// subdirectory, with the entry files pointing at the blob of their value by
+// its SHA-256. Blobs are reference counted and removed with their last entry.
+// Backup and Restore include the blobs.
+func WithDedup[T any]() Option[T] {
+	return func(storage *Storage[T]) {
+		storage.dedup = true
+	}
+}
+
+// WithReadOnly makes every method that would write to the storage directory
+// fail with ErrReadOnly, before taking any lock. Expired entries are reported
+// as missing but left on disk, and Init only checks that the directory exists.
+func WithReadOnly[T any]() Option[T] {
+	return func(storage *Storage[T]) {
+		storage.readOnly = true
+	}
+}
+
+// WithReadFallback adds read-only directories searched in order when an entry
+// is not in the storage directory. The methods reading entries or listing keys
+// see the union of the directories, a key in the storage directory shadowing
+// the same key in the fallbacks and an earlier fallback shadowing a later one.
+// Stat, DiskUsage, Verify, Backup and Watch work on the files of the storage
+// directory only. Writes only go to the storage directory, and the methods
+// modifying entries, such as Edit, Update or DeleteWhere, only read from it,
+// so deleting a key there uncovers its copy in a fallback, if any.
+func WithReadFallback[T any](dirs ...string) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.fallbackDirs = append(storage.fallbackDirs, dirs...)
+	}
+}
+
+// WithMaxEntries bounds the storage to n entries. Putting a new key into a
+// full storage first removes the entries chosen by policy; overwriting an
+// existing key does not. Each eviction stats every file, and single-key writes
+// take the storage-wide write lock.
+func WithMaxEntries[T any](n int, policy EvictionPolicy) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.maxEntries = n
+		storage.evictionPolicy = policy
+	}
+}
+
+// WithHierarchicalKeys stores keys containing "/" in nested directories
+// mirroring the key, e.g. "a/b/c" as a/b/c.json, instead of escaping the
+// slashes. Empty segments are ignored, and "." and ".." segments are escaped
+// so that keys stay inside the storage directory. Sharding does not apply, and
+// Scope panics since its namespaces would be listed as keys.
+func WithHierarchicalKeys[T any]() Option[T] {
+	return func(storage *Storage[T]) {
+		storage.hierarchical = true
+	}
+}
+
+// WithWriteBuffer keeps the entries put and deleted in memory until Flush
+// writes them to disk in one pass. Reads and listings, RangeRaw included, see
+// the buffered writes, but Stat, Touch, GetRaw, GetReader, Undelete, Backup,
+// Verify, DiskUsage and Watch work on the files and only see them once
+// flushed. PutRaw writes to disk at once, replacing a write to the same key
+// still buffered. WithMaxEntries evicts when a write is buffered, so buffered
+// entries count towards it. Buffered writes are lost if the process exits
+// before Flush.
+func WithWriteBuffer[T any]() Option[T] {
+	return func(storage *Storage[T]) {
+		storage.bufferWrites = true
+	}
+}
+
+// WithChecksum stores the SHA-256 of each value along with it and verifies it
+// when the file is read, failing with ErrChecksumMismatch if the value was
+// altered on disk. The checksum is of the value as encoded in the file, so it
+// is verified before the value is decoded into T. Files written without a
+// checksum are read unverified. GetReader does not verify streamed values. It
+// requires the default JSON codec.
+func WithChecksum[T any]() Option[T] {
+	return func(storage *Storage[T]) {
+		storage.checksum = true
+	}
+}
+
+// WithDecoderOptions calls configure on every json.Decoder reading values,
+// e.g. to call DisallowUnknownFields or UseNumber. It applies to the default
+// JSON codec, migrations and imports. Metadata is always decoded leniently.
+func WithDecoderOptions[T any](configure func(*json.Decoder)) Option[T] {
+	return func(storage *Storage[T]) {
+		storage.decoderOptions = configure
+		if codec, ok := storage.codec.(jsonCodec); ok {
+			codec.configure = configure
+			storage.codec = codec
+		}
+	}
+}
+
+// WithFlatFormat writes only the value to each file, without the key and
+// metadata around it, so that other programs can read the files as is. Keys
+// are decoded from the file names and must fit in one. Expiry, versions,
+// timestamps other than the modification time, checksums, deduplication and
+// migrations need the metadata and are unavailable. Without WithFlatFormat a
+// flat file fails to decode with ErrDecode, as it holds no key, and with it a
+// file holding its key and metadata around the value does as well. Values that
+// would be taken for such a file are rejected with ErrInvalidValue.
+func WithFlatFormat[T any]() Option[T] {
+	return func(storage *Storage[T]) {
+		storage.flat = true
+	}
+}
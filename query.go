@@ -0,0 +1,164 @@
+package jsonstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Filter returns the entries for which pred returns true. The matches are
+// held in memory, so it is meant for reasonably sized results.
+func (storage *Storage[T]) Filter(pred func(string, T) bool) (map[string]T, error) {
+	entries := map[string]T{}
+	err := storage.Range(func(key string, value T) error {
+		if pred(key, value) {
+			entries[key] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// errFound stops Range once Find has a match.
+var errFound = errors.New("found")
+
+// Find returns the first entry for which pred returns true, stopping the scan
+// there. The bool reports whether any entry matched.
+func (storage *Storage[T]) Find(pred func(string, T) bool) (string, T, bool, error) {
+	unlock := storage.rlockAll()
+	defer unlock()
+
+	names, err := storage.names()
+	if err != nil {
+		return "", *new(T), false, fmt.Errorf("%w: failed to find JSON: %s", ErrInternal, err)
+	}
+
+	foundKey, foundValue := "", *new(T)
+	find := func(key string, value T) error {
+		if pred(key, value) {
+			foundKey, foundValue = key, value
+			return errFound
+		}
+		return nil
+	}
+
+	err = storage.rangeNames(context.Background(), names, find)
+	if err == nil {
+		err = storage.rangeFallbacks(context.Background(), names, find)
+	}
+	if err != nil {
+		if errors.Is(err, errFound) {
+			return foundKey, foundValue, true, nil
+		}
+		return "", *new(T), false, err
+	}
+
+	return "", *new(T), false, nil
+}
+
+// DeleteWhere removes the entries for which pred returns true and returns how
+// many were removed, including when it stops early on an error.
+func (storage *Storage[T]) DeleteWhere(pred func(string, T) bool) (int, error) {
+	if err := storage.writable(); err != nil {
+		return 0, err
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	names, err := storage.names()
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to delete JSONs: %s", ErrInternal, err)
+	}
+
+	count := 0
+	for _, name := range names {
+		path := filepath.Join(storage.dir, name)
+
+		ent, err := storage.get(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return count, fmt.Errorf("%w: failed to delete JSONs: %s", ErrInternal, err)
+		}
+
+		if !pred(ent.Key, ent.Value) {
+			continue
+		}
+
+		if err := storage.beforeDelete(ent.Key); err != nil {
+			return count, err
+		}
+
+		storage.cache.remove(path)
+		if err := storage.remove(path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return count, &InternalError{Key: ent.Key, Msg: "failed to delete JSON", Err: err}
+		}
+		count++
+
+		storage.afterDelete(ent.Key)
+	}
+
+	return count, nil
+}
+
+// Values returns the values of all entries. It loads the whole storage into
+// memory, so it is not meant for large storages.
+func (storage *Storage[T]) Values() ([]T, error) {
+	values := []T{}
+	err := storage.Range(func(key string, value T) error {
+		values = append(values, value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// Entries returns all entries keyed by key. It loads the whole storage into
+// memory, so it is not meant for large storages.
+func (storage *Storage[T]) Entries() (map[string]T, error) {
+	return storage.Filter(func(string, T) bool { return true })
+}
+
+// Snapshot is like Entries but holds the storage-wide write lock while it
+// copies the entries. Entries shares the read lock, which keeps writers of this
+// Storage out but lets concurrent reads remove expired entries midway; with
+// Snapshot nothing else touches the storage until the copy is done, at the
+// cost of blocking every read and write meanwhile.
+func (storage *Storage[T]) Snapshot() (map[string]T, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	names, err := storage.names()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to snapshot JSONs: %s", ErrInternal, err)
+	}
+
+	entries := make(map[string]T, len(names))
+	collect := func(key string, value T) error {
+		entries[key] = value
+		return nil
+	}
+
+	if err := storage.rangeNames(context.Background(), names, collect); err != nil {
+		return nil, err
+	}
+	if err := storage.rangeFallbacks(context.Background(), names, collect); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
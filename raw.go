@@ -0,0 +1,451 @@
+package jsonstorage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/thamaji/fstools"
+)
+
+// GetRaw returns the value of key as raw JSON, without decoding it into T.
+// It requires the default JSON codec.
+func (storage *Storage[T]) GetRaw(key string) ([]byte, error) {
+	if err := storage.checkKey(key); err != nil {
+		return nil, err
+	}
+
+	path := storage.path(key)
+
+	unlock := storage.rlockKey(path)
+	defer unlock()
+
+	ent, err := storage.getRaw(path)
+	if errors.Is(err, os.ErrNotExist) && len(storage.fallbacks) > 0 {
+		ent, err = storage.fallbackGetRaw(key)
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, &NotExistError{Key: key}
+		}
+		return nil, &InternalError{Key: key, Msg: "failed to get JSON", Err: err}
+	}
+
+	return ent.Value, nil
+}
+
+// GetReader returns a reader streaming the value of key as raw JSON from its
+// file, without decoding it into T or reading it into memory. The caller must
+// close the reader to release the file. Compressed or encrypted values cannot
+// be streamed, so they are read into memory as with GetRaw. Streamed values
+// are not verified against the checksum of WithChecksum, as they are not read
+// before being returned. It requires the default JSON codec.
+func (storage *Storage[T]) GetReader(key string) (io.ReadCloser, error) {
+	if storage.compress || storage.aead != nil {
+		raw, err := storage.GetRaw(key)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(raw)), nil
+	}
+
+	if err := storage.checkKey(key); err != nil {
+		return nil, err
+	}
+
+	path := storage.path(key)
+
+	unlock := storage.rlockKey(path)
+	defer unlock()
+
+	rc, err := storage.openValue(path)
+	for _, fallback := range storage.fallbacks {
+		if !errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		rc, err = fallback.openValue(fallback.path(key))
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, &NotExistError{Key: key}
+		}
+		return nil, &InternalError{Key: key, Msg: "failed to get JSON", Err: err}
+	}
+
+	return rc, nil
+}
+
+// openValue opens the file at path and returns a reader over the bytes of the
+// value in it, or in its blob with WithDedup. Only the fields around the value
+// are decoded, to find where it is and whether it has expired.
+func (storage *Storage[T]) openValue(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, storage.dirIsFile(err)
+		}
+		return nil, err
+	}
+
+	if storage.flat {
+		return openBare(file)
+	}
+
+	er := &errReader{r: file}
+	meta, start, end, err := scanEntry(json.NewDecoder(er))
+	if err != nil {
+		file.Close()
+		if er.err != nil {
+			return nil, er.err
+		}
+		return nil, fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+
+	switch {
+	case meta.Key == "":
+		file.Close()
+		return nil, errNoWrapper
+
+	case meta.ExpiresAt != nil && !storage.now().Before(*meta.ExpiresAt):
+		file.Close()
+		return nil, os.ErrNotExist
+
+	case meta.Blob != "":
+		file.Close()
+		blob, err := os.Open(storage.blobPath(meta.Blob))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("%w: missing blob: %s", ErrDecode, meta.Blob)
+			}
+			return nil, err
+		}
+		return openBare(blob)
+
+	case start < 0:
+		file.Close()
+		return nil, fmt.Errorf("%w: no value in file", ErrDecode)
+	}
+
+	return openSection(file, start, end)
+}
+
+// openBare returns a reader over the value file holds on its own, as a flat
+// file or a blob does.
+func openBare(file *os.File) (io.ReadCloser, error) {
+	er := &errReader{r: file}
+	dec := json.NewDecoder(er)
+	if err := skipValue(dec); err != nil {
+		file.Close()
+		if er.err != nil {
+			return nil, er.err
+		}
+		return nil, fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+
+	return openSection(file, 0, dec.InputOffset())
+}
+
+// scanEntry reads an entry from dec field by field, skipping its value without
+// keeping it. It returns the other fields and the offsets between which the
+// value lies, preceded by the colon after its name. The offsets are -1 if the
+// entry has no value.
+func scanEntry(dec *json.Decoder) (pointer, int64, int64, error) {
+	if tok, err := dec.Token(); err != nil {
+		return pointer{}, 0, 0, err
+	} else if tok != json.Delim('{') {
+		return pointer{}, 0, 0, fmt.Errorf("entry is not an object")
+	}
+
+	start, end := int64(-1), int64(-1)
+	fields := map[string]json.RawMessage{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return pointer{}, 0, 0, err
+		}
+		name, _ := tok.(string)
+
+		if name == "value" {
+			start = dec.InputOffset()
+			if err := skipValue(dec); err != nil {
+				return pointer{}, 0, 0, err
+			}
+			end = dec.InputOffset()
+			continue
+		}
+
+		field := json.RawMessage{}
+		if err := dec.Decode(&field); err != nil {
+			return pointer{}, 0, 0, err
+		}
+		fields[name] = field
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return pointer{}, 0, 0, err
+	}
+
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return pointer{}, 0, 0, err
+	}
+
+	meta := pointer{}
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return pointer{}, 0, 0, err
+	}
+
+	return meta, start, end, nil
+}
+
+// skipValue reads the next value from dec token by token, so that a large
+// value is never held in memory at once.
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := tok.(json.Delim); ok {
+			if delim == '{' || delim == '[' {
+				depth++
+			} else {
+				depth--
+			}
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// openSection returns a reader over the value between the offsets start and
+// end of file, skipping the colon and whitespace before it. Closing the reader
+// closes file.
+func openSection(file *os.File, start, end int64) (io.ReadCloser, error) {
+	br := bufio.NewReader(io.NewSectionReader(file, start, end-start))
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if c != ':' && c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			br.UnreadByte()
+			break
+		}
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{br, file}, nil
+}
+
+// RangeRaw is like Range but passes the values as raw JSON, without decoding
+// them into T. It requires the default JSON codec.
+func (storage *Storage[T]) RangeRaw(f func(key string, raw json.RawMessage) error) error {
+	unlock := storage.rlockAll()
+	defer unlock()
+
+	names, err := storage.names()
+	if err != nil {
+		return fmt.Errorf("%w: failed to range JSONs: %s", ErrInternal, err)
+	}
+
+	if err := storage.rangeRawNames(names, f); err != nil {
+		return err
+	}
+
+	layers, err := storage.fallbackNames(names)
+	if err != nil {
+		return fmt.Errorf("%w: failed to range JSONs: %s", ErrInternal, err)
+	}
+	for i, fallback := range storage.fallbacks {
+		if err := fallback.rangeRawNames(layers[i], f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rangeRawNames is rangeNames for RangeRaw, with the same skip rules.
+func (storage *Storage[T]) rangeRawNames(names []string, f func(string, json.RawMessage) error) error {
+	for _, name := range names {
+		path := filepath.Join(storage.dir, name)
+
+		ent, err := storage.rangeRaw(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if storage.skipCorrupt {
+				if storage.onCorrupt != nil {
+					key, _ := storage.key(name)
+					storage.onCorrupt(key, path, err)
+				}
+				continue
+			}
+			return fmt.Errorf("%w: failed to range JSONs: %w", ErrInternal, err)
+		}
+
+		if err := f(ent.Key, ent.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rangeRaw reads the entry at path as getRaw does, unless a write to it is
+// buffered, whose value is then marshaled as the raw JSON.
+func (storage *Storage[T]) rangeRaw(path string) (entry[json.RawMessage], error) {
+	if _, ok := storage.buffered(path); !ok {
+		return storage.getRaw(path)
+	}
+
+	ent, err := storage.get(path)
+	if err != nil {
+		return entry[json.RawMessage]{}, err
+	}
+
+	raw, err := json.Marshal(ent.Value)
+	if err != nil {
+		return entry[json.RawMessage]{}, fmt.Errorf("%w: %w", ErrEncode, err)
+	}
+
+	return entry[json.RawMessage]{Key: ent.Key, Value: raw, entryMeta: ent.entryMeta}, nil
+}
+
+// PutRaw stores raw as the value of key, without encoding it from T. raw must
+// be well-formed JSON, otherwise ErrInvalidValue is returned. With a validator
+// or put hooks, raw is also decoded into T to be passed to them, failing with
+// ErrInvalidValue if it does not decode. With WithWriteBuffer, raw is written
+// to disk at once, replacing a write to key still buffered. It requires the
+// default JSON codec.
+func (storage *Storage[T]) PutRaw(key string, raw []byte) error {
+	if err := storage.writable(); err != nil {
+		return err
+	}
+
+	if err := storage.checkKey(key); err != nil {
+		return err
+	}
+
+	if !json.Valid(raw) {
+		return fmt.Errorf("%w: malformed JSON: %s", ErrInvalidValue, key)
+	}
+
+	if storage.flat {
+		if err := storage.checkFlat(key, storage.path(key), raw); err != nil {
+			return err
+		}
+	}
+
+	value := *new(T)
+	if storage.validator != nil || storage.beforePutHook != nil || storage.afterPutHook != nil {
+		if err := storage.newDecoder(bytes.NewReader(raw)).Decode(&value); err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrInvalidValue, key, err)
+		}
+	}
+
+	path := storage.path(key)
+
+	unlock := storage.lockKey(path)
+	defer unlock()
+
+	if err := storage.validate(key, value); err != nil {
+		return err
+	}
+
+	if err := storage.beforePut(key, value); err != nil {
+		return err
+	}
+
+	// Close may have flushed the write buffer while this waited for the lock.
+	if err := storage.closed(); err != nil {
+		return err
+	}
+
+	meta, err := storage.stampMeta(path, entryMeta{}, raw)
+	if err != nil {
+		return &InternalError{Key: key, Msg: "failed to put JSON", Err: err}
+	}
+	ent := entry[json.RawMessage]{Key: key, Value: raw, entryMeta: meta}
+
+	if err := storage.evict(path); err != nil {
+		return &InternalError{Key: key, Msg: "failed to put JSON", Err: err}
+	}
+
+	storage.cache.remove(path)
+
+	switch {
+	case storage.flat:
+		err = storage.writeFile(path, func(w io.Writer) error {
+			return storage.encode(w, ent.Value)
+		})
+	case storage.dedup:
+		err = storage.putDedup(path, key, ent.entryMeta, ent.Value)
+	default:
+		err = storage.writeFile(path, func(w io.Writer) error {
+			return storage.encode(w, ent)
+		})
+	}
+	if err != nil {
+		return &InternalError{Key: key, Msg: "failed to put JSON", Err: err}
+	}
+
+	// The file now supersedes a write still buffered for key, which would
+	// otherwise overwrite it on the next Flush.
+	storage.unbuffer(path)
+
+	storage.afterPut(key, value)
+
+	return nil
+}
+
+// getRaw reads the entry at path leaving its value undecoded, verifying it
+// against its checksum. Expired entries are reported as os.ErrNotExist.
+func (storage *Storage[T]) getRaw(path string) (entry[json.RawMessage], error) {
+	ent := entry[json.RawMessage]{}
+	err := fstools.ReadFileFunc(path, func(r io.Reader) error {
+		if storage.flat {
+			key, err := storage.decodeFlat(r, path, &ent.Value)
+			ent.Key = key
+			return err
+		}
+		if err := storage.decode(r, &ent); err != nil {
+			return err
+		}
+		if ent.Key == "" {
+			return errNoWrapper
+		}
+		return nil
+	})
+	if err != nil {
+		return entry[json.RawMessage]{}, err
+	}
+
+	if ent.Blob != "" {
+		if err := storage.readBlob(ent.Blob, &ent.Value); err != nil {
+			return entry[json.RawMessage]{}, err
+		}
+	}
+
+	if err := storage.verifyChecksum(ent.Key, ent.Checksum, ent.Value); err != nil {
+		return entry[json.RawMessage]{}, err
+	}
+
+	if ent.ExpiresAt != nil && !storage.now().Before(*ent.ExpiresAt) {
+		return entry[json.RawMessage]{}, os.ErrNotExist
+	}
+
+	return ent, nil
+}
@@ -0,0 +1,22 @@
+package jsonstorage
+
+// writable returns ErrReadOnly if the storage was opened with WithReadOnly and
+// ErrClosed once it is closed. Mutators call it before taking any lock.
+func (storage *Storage[T]) writable() error {
+	if storage.readOnly {
+		return ErrReadOnly
+	}
+	return storage.closed()
+}
+
+// closed returns ErrClosed once the storage is closed. put and remove check it
+// again under the lock, since Close may have flushed the write buffer for the
+// last time while the caller waited for the lock.
+func (storage *Storage[T]) closed() error {
+	select {
+	case <-storage.done:
+		return ErrClosed
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,169 @@
+package jsonstorage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// reservationExt is appended to the file name of an entry for its reservation.
+const reservationExt = ".lock"
+
+// Reserve claims key by creating a lock file next to its JSON with O_EXCL, so
+// that exactly one caller across processes wins. It returns false if key is
+// already reserved. The reservation lapses after ttl, letting another caller
+// claim key if the holder died without calling Release.
+func (storage *Storage[T]) Reserve(key string, ttl time.Duration) (bool, error) {
+	if err := storage.writable(); err != nil {
+		return false, err
+	}
+
+	if err := storage.checkKey(key); err != nil {
+		return false, err
+	}
+
+	path := storage.path(key) + reservationExt
+
+	if err := storage.mkdirAll(filepath.Dir(path)); err != nil {
+		return false, &InternalError{Key: key, Msg: "failed to reserve JSON", Err: err}
+	}
+
+	for {
+		ok, err := storage.reserve(path, ttl)
+		if err != nil {
+			return false, &InternalError{Key: key, Msg: "failed to reserve JSON", Err: err}
+		}
+		if ok {
+			return true, nil
+		}
+
+		broken, err := storage.breakReservation(path, ttl)
+		if err != nil {
+			return false, &InternalError{Key: key, Msg: "failed to reserve JSON", Err: err}
+		}
+		if !broken {
+			return false, nil
+		}
+	}
+}
+
+// Release drops the reservation of key. Releasing a key that is not reserved
+// is not an error.
+func (storage *Storage[T]) Release(key string) error {
+	if err := storage.writable(); err != nil {
+		return err
+	}
+
+	if err := storage.checkKey(key); err != nil {
+		return err
+	}
+
+	if err := os.Remove(storage.path(key) + reservationExt); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return &InternalError{Key: key, Msg: "failed to release JSON", Err: err}
+	}
+
+	return nil
+}
+
+// reserve creates the lock file at path holding its expiry time, with the mode
+// of WithFileMode as the JSONs are. It returns false if the file already
+// exists.
+func (storage *Storage[T]) reserve(path string, ttl time.Duration) (bool, error) {
+	mode := os.FileMode(0644)
+	if storage.fileMode != 0 {
+		mode = storage.fileMode
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	// The mode is set again so that it is not narrowed by the umask.
+	if storage.fileMode != 0 {
+		err = file.Chmod(storage.fileMode)
+	}
+	if err == nil {
+		_, err = file.WriteString(storage.now().Add(ttl).Format(time.RFC3339Nano))
+	}
+	if err1 := file.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(path)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// breakReservation removes the lock file at path if it has lapsed, reporting
+// whether the caller should try to reserve again. Removing it by path could
+// remove a fresh lock created by another caller that broke it first, so the
+// lock is moved to a name of its own and checked again there: a fresh lock is
+// put back, unless yet another caller has reserved the key meanwhile.
+func (storage *Storage[T]) breakReservation(path string, ttl time.Duration) (bool, error) {
+	expired, err := storage.reservationExpired(path, ttl)
+	if err != nil || !expired {
+		return false, err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return false, err
+	}
+	temp.Close()
+	defer os.Remove(temp.Name())
+
+	if err := os.Rename(path, temp.Name()); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	expired, err = storage.reservationExpired(temp.Name(), ttl)
+	if err == nil && expired {
+		return true, nil
+	}
+
+	if linkErr := os.Link(temp.Name(), path); linkErr != nil && !errors.Is(linkErr, os.ErrExist) {
+		return false, linkErr
+	}
+
+	return false, err
+}
+
+// reservationExpired reports whether the lock file at path has lapsed. A file
+// that vanished counts as lapsed so that the caller tries again. A file whose
+// expiry cannot be read, e.g. because its holder died while writing it, lapses
+// ttl after its modification time.
+func (storage *Storage[T]) reservationExpired(path string, ttl time.Duration) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	if err != nil {
+		expiresAt = info.ModTime().Add(ttl)
+	}
+
+	return !storage.now().Before(expiresAt), nil
+}
@@ -0,0 +1,42 @@
+package jsonstorage
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"time"
+)
+
+// transientErrors are retried with WithRetry unless other errors are given.
+var transientErrors = []error{syscall.EAGAIN, syscall.ESTALE, syscall.EINTR}
+
+// retry calls f until it succeeds, fails with an error that is not transient
+// or the attempts of WithRetry are used up. The backoff doubles after each
+// attempt, and retrying stops early when ctx is done.
+func (storage *Storage[T]) retry(ctx context.Context, f func() error) error {
+	backoff := storage.retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= storage.retryAttempts || !storage.transient(err) {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+func (storage *Storage[T]) transient(err error) bool {
+	for _, target := range storage.retryErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
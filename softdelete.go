@@ -0,0 +1,115 @@
+package jsonstorage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// tombstoneExt is appended to the file name of a JSON deleted with
+// WithSoftDelete. Tombstones no longer end with the extension, so they are
+// invisible to Get and Range.
+const tombstoneExt = ".deleted"
+
+// remove deletes the entry at path, or buffers its deletion with
+// WithWriteBuffer.
+func (storage *Storage[T]) remove(path string) error {
+	if err := storage.closed(); err != nil {
+		return err
+	}
+
+	if storage.buffer != nil {
+		if !storage.exists(path) {
+			return os.ErrNotExist
+		}
+		storage.bufferPut(path, buffered[T]{deleted: true})
+		return nil
+	}
+	return storage.removeFile(path)
+}
+
+// removeFile deletes the file at path, or turns it into a tombstone with
+// WithSoftDelete.
+func (storage *Storage[T]) removeFile(path string) error {
+	if storage.softDelete {
+		return os.Rename(path, path+tombstoneExt)
+	}
+	return storage.unlink(path)
+}
+
+// removeTombstone drops the tombstone of path left by an earlier soft delete.
+func (storage *Storage[T]) removeTombstone(path string) error {
+	if !storage.softDelete {
+		return nil
+	}
+	if err := storage.unlink(path + tombstoneExt); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Undelete brings back the JSON of key deleted with WithSoftDelete. It fails
+// with ErrNotExist if there is no tombstone for key and with ErrAlreadyExists
+// if key has been put again since.
+func (storage *Storage[T]) Undelete(key string) error {
+	if err := storage.writable(); err != nil {
+		return err
+	}
+
+	if err := storage.checkKey(key); err != nil {
+		return err
+	}
+
+	path := storage.path(key)
+
+	unlock := storage.lockKey(path)
+	defer unlock()
+
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%w: %s", ErrAlreadyExists, key)
+	}
+
+	if err := os.Rename(path+tombstoneExt, path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &NotExistError{Key: key}
+		}
+		return &InternalError{Key: key, Msg: "failed to undelete JSON", Err: err}
+	}
+
+	storage.cache.remove(path)
+
+	return nil
+}
+
+// Purge permanently removes the tombstones left by WithSoftDelete and returns
+// how many were removed.
+func (storage *Storage[T]) Purge() (int, error) {
+	if err := storage.writable(); err != nil {
+		return 0, err
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	names := []string{}
+	if err := storage.walk("", storage.walkDepth(), storage.ext+tombstoneExt, &names); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("%w: failed to purge JSONs: %s", ErrInternal, err)
+	}
+
+	count := 0
+	for _, name := range names {
+		if err := storage.unlink(filepath.Join(storage.dir, name)); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return count, fmt.Errorf("%w: failed to purge JSONs: %s", ErrInternal, err)
+		}
+		count++
+	}
+
+	return count, nil
+}
@@ -1,61 +1,429 @@
 package jsonstorage
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/thamaji/fstools"
 )
 
-func New[T any](dir string) *Storage[T] {
-	return &Storage[T]{dir: dir, mutex: sync.RWMutex{}}
+// New returns a Storage keeping its entries in dir. Without opts it stores
+// lowercased keys as compact JSON files.
+func New[T any](dir string, opts ...Option[T]) *Storage[T] {
+	storage := &Storage[T]{
+		dir:           dir,
+		mutex:         sync.RWMutex{},
+		opts:          opts,
+		codec:         jsonCodec{},
+		now:           time.Now,
+		maxNameLength: 255,
+		normalizeKey:  strings.ToLower,
+		encodeKey:     url.PathEscape,
+		decodeKey:     url.PathUnescape,
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(storage)
+	}
+	if storage.bufferWrites {
+		storage.buffer = map[string]buffered[T]{}
+	}
+	for _, dir := range storage.fallbackDirs {
+		fallback := New(dir, append(opts[:len(opts):len(opts)], func(fallback *Storage[T]) {
+			fallback.fallbackDirs = nil
+			fallback.readOnly = true
+		})...)
+		storage.fallbacks = append(storage.fallbacks, fallback)
+	}
+	if storage.ext == "" {
+		storage.ext = storage.codec.Ext()
+		if storage.compress {
+			storage.ext += ".gz"
+		}
+	}
+	return storage
+}
+
+// Init creates the storage directory if it does not exist and checks that it
+// is a writable directory. It is safe to call more than once.
+func (storage *Storage[T]) Init() error {
+	if storage.readOnly {
+		info, err := os.Stat(storage.dir)
+		if err != nil {
+			return fmt.Errorf("%w: failed to init storage: %s", ErrInternal, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%w: failed to init storage: storage dir is a file: %s", ErrInternal, storage.dir)
+		}
+		return nil
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	if err := storage.mkdirAll(storage.dir); err != nil {
+		return fmt.Errorf("%w: failed to init storage: %s", ErrInternal, storage.dirIsFile(err))
+	}
+
+	temp, err := os.CreateTemp(storage.dir, ".init")
+	if err != nil {
+		return fmt.Errorf("%w: failed to init storage: storage dir is not writable: %s", ErrInternal, err)
+	}
+	temp.Close()
+
+	if err := os.Remove(temp.Name()); err != nil {
+		return fmt.Errorf("%w: failed to init storage: %s", ErrInternal, err)
+	}
+
+	return nil
+}
+
+// Close flushes the write buffer, stops the goroutines of Watch and makes the
+// methods writing to the storage fail with ErrClosed from then on. Reads keep
+// working. Calling Close again does nothing and returns nil.
+func (storage *Storage[T]) Close() error {
+	err := error(nil)
+	storage.closeOnce.Do(func() {
+		storage.mutex.Lock()
+		defer storage.mutex.Unlock()
+
+		err = storage.flush()
+		close(storage.done)
+	})
+	return err
 }
 
 type Storage[T any] struct {
-	dir   string
-	mutex sync.RWMutex
+	dir      string
+	mutex    sync.RWMutex
+	opts     []Option[T]
+	codec    Codec
+	ext      string
+	compress bool
+
+	normalizeKey func(string) string
+	skipCorrupt  bool
+	onCorrupt    func(key string, path string, err error)
+	strictGetAll bool
+
+	cache   *cache[T]
+	stripes []sync.RWMutex
+
+	observer func(op string, key string, err error, dur time.Duration)
+	logger   func(ctx context.Context, msg string, args ...any)
+
+	now func() time.Time
+
+	aead cipher.AEAD
+
+	maxNameLength int
+	shardDepth    int
+	fileMode      os.FileMode
+	dirMode       os.FileMode
+	sync          bool
+	softDelete    bool
+	checksum      bool
+	readOnly      bool
+	hierarchical  bool
+	flat          bool
+
+	maxEntries     int
+	evictionPolicy EvictionPolicy
+	accessed       sync.Map
+
+	buffer      map[string]buffered[T]
+	bufferMutex sync.Mutex
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	fallbackDirs []string
+	bufferWrites bool
+	fallbacks    []*Storage[T]
+
+	retryAttempts int
+	retryBackoff  time.Duration
+	retryErrors   []error
+
+	dedup     bool
+	blobMutex sync.Mutex
+
+	validator        func(key string, value T) error
+	strictValidation bool
+
+	beforePutHook    func(key string, value T) error
+	afterPutHook     func(key string, value T)
+	beforeDeleteHook func(key string) error
+	afterDeleteHook  func(key string)
+
+	encodeKey func(string) string
+	decodeKey func(string) (string, error)
+
+	schemaVersion int
+	migrate       func(version int, raw json.RawMessage) (T, error)
+
+	decoderOptions func(*json.Decoder)
 }
 
 type entry[T any] struct {
 	Key   string `json:"key"`
 	Value T      `json:"value"`
+	entryMeta
+}
+
+// entryMeta holds the fields of an entry besides its key and value. They are
+// all optional so that files written before they were added still decode.
+type entryMeta struct {
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	SchemaVersion int        `json:"schema_version,omitempty"`
+	Blob          string     `json:"blob,omitempty"`
+	Version       uint64     `json:"version,omitempty"`
+	CreatedAt     *time.Time `json:"created_at,omitempty"`
+	UpdatedAt     *time.Time `json:"updated_at,omitempty"`
+	Checksum      string     `json:"checksum,omitempty"`
+}
+
+// Scope returns a Storage with the same options kept in a subdirectory named
+// after namespace, independent of the entries of this one. The fallback
+// directories of WithReadFallback are scoped to the same subdirectory. It
+// panics if namespace is empty or consists only of whitespace, and if the
+// storage uses WithHierarchicalKeys, whose key directories could not be told
+// from the namespaces.
+func (storage *Storage[T]) Scope(namespace string) *Storage[T] {
+	if storage.hierarchical {
+		panic("jsonstorage: Scope is not supported with WithHierarchicalKeys")
+	}
+
+	if err := validateKey(namespace); err != nil {
+		panic("jsonstorage: invalid namespace: " + err.Error())
+	}
+
+	name := url.PathEscape(namespace)
+	if name == "." || name == ".." {
+		name = strings.ReplaceAll(name, ".", "%2E")
+	}
+
+	opts := append(storage.opts[:len(storage.opts):len(storage.opts)], func(scoped *Storage[T]) {
+		dirs := make([]string, 0, len(scoped.fallbackDirs))
+		for _, dir := range scoped.fallbackDirs {
+			dirs = append(dirs, filepath.Join(dir, name))
+		}
+		scoped.fallbackDirs = dirs
+	})
+
+	return New(filepath.Join(storage.dir, name), opts...)
 }
 
 func (storage *Storage[T]) Range(f func(string, T) error) error {
-	storage.mutex.RLock()
-	defer storage.mutex.RUnlock()
+	return storage.RangeContext(context.Background(), f)
+}
+
+// RangeContext is like Range but checks ctx before reading each JSON, stopping
+// with the error of ctx once it is done.
+func (storage *Storage[T]) RangeContext(ctx context.Context, f func(string, T) error) (err error) {
+	if storage.observer != nil {
+		defer storage.observe("range", "", time.Now(), &err)
+	}
+
+	unlock := storage.rlockAll()
+	defer unlock()
 
-	direntries, err := fstools.ReadDir(storage.dir)
+	names, err := storage.names()
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil
+		return fmt.Errorf("%w: failed to range JSONs: %s", ErrInternal, err)
+	}
+
+	if err := storage.rangeNames(ctx, names, f); err != nil {
+		return err
+	}
+
+	return storage.rangeFallbacks(ctx, names, f)
+}
+
+// RangeSorted is like Range but visits the JSONs in file name order.
+func (storage *Storage[T]) RangeSorted(f func(string, T) error) error {
+	unlock := storage.rlockAll()
+	defer unlock()
+
+	names, err := storage.names()
+	if err != nil {
+		return fmt.Errorf("%w: failed to range JSONs: %s", ErrInternal, err)
+	}
+
+	all, err := storage.layeredNames(names)
+	if err != nil {
+		return fmt.Errorf("%w: failed to range JSONs: %s", ErrInternal, err)
+	}
+	sortLayered(all)
+
+	for _, l := range all {
+		if err := l.storage.rangeNames(context.Background(), []string{l.name}, f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// All returns an iterator over the entries in the storage. Unlike Range, JSONs
+// that cannot be listed or decoded are silently skipped.
+func (storage *Storage[T]) All() iter.Seq2[string, T] {
+	return func(yield func(string, T) bool) {
+		unlock := storage.rlockAll()
+		defer unlock()
+
+		names, err := storage.names()
+		if err != nil {
+			return
+		}
+
+		all, err := storage.layeredNames(names)
+		if err != nil {
+			return
+		}
+
+		for _, l := range all {
+			ent, err := l.storage.get(l.path())
+			if err != nil {
+				continue
+			}
+
+			if !yield(ent.Key, ent.Value) {
+				return
+			}
 		}
+	}
+}
+
+// RangeBatch is like Range but calls f with up to size entries at a time, the
+// keys and values at the same indexes, and a final partial batch at the end.
+// A size below 1 is treated as 1.
+func (storage *Storage[T]) RangeBatch(size int, f func([]string, []T) error) error {
+	size = max(size, 1)
+
+	unlock := storage.rlockAll()
+	defer unlock()
+
+	names, err := storage.names()
+	if err != nil {
 		return fmt.Errorf("%w: failed to range JSONs: %s", ErrInternal, err)
 	}
 
-	for _, direntry := range direntries {
-		if direntry.IsDir() {
-			continue
+	keys, values := make([]string, 0, size), make([]T, 0, size)
+	batch := func(key string, value T) error {
+		keys, values = append(keys, key), append(values, value)
+		if len(keys) < size {
+			return nil
 		}
+		err := f(keys, values)
+		keys, values = make([]string, 0, size), make([]T, 0, size)
+		return err
+	}
 
-		name := direntry.Name()
-		if filepath.Ext(name) != ".json" {
-			continue
+	if err := storage.rangeNames(context.Background(), names, batch); err != nil {
+		return err
+	}
+	if err := storage.rangeFallbacks(context.Background(), names, batch); err != nil {
+		return err
+	}
+
+	if len(keys) > 0 {
+		return f(keys, values)
+	}
+
+	return nil
+}
+
+// RangeParallel is like Range but reads the JSONs with up to workers
+// goroutines, calling f concurrently from them, so f must be safe for
+// concurrent use. The first error stops the remaining work and is returned.
+// A workers below 1 is treated as 1.
+func (storage *Storage[T]) RangeParallel(workers int, f func(string, T) error) error {
+	workers = max(workers, 1)
+
+	unlock := storage.rlockAll()
+	defer unlock()
+
+	names, err := storage.names()
+	if err != nil {
+		return fmt.Errorf("%w: failed to range JSONs: %s", ErrInternal, err)
+	}
+
+	all, err := storage.layeredNames(names)
+	if err != nil {
+		return fmt.Errorf("%w: failed to range JSONs: %s", ErrInternal, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	jobs := make(chan layered[T])
+	go func() {
+		defer close(jobs)
+		for _, l := range all {
+			select {
+			case jobs <- l:
+			case <-ctx.Done():
+				return
+			}
 		}
+	}()
 
-		path := filepath.Join(storage.dir, name)
+	var once sync.Once
+	var firstErr error
+	wg := sync.WaitGroup{}
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for l := range jobs {
+				if err := l.storage.rangeNames(ctx, []string{l.name}, f); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+	wg.Wait()
 
-		ent := entry[T]{}
-		err := fstools.ReadFileFunc(path, func(r io.Reader) error {
-			return json.NewDecoder(r).Decode(&ent)
-		})
+	return firstErr
+}
+
+// RangeSince is like Range but only visits the JSONs modified after since.
+func (storage *Storage[T]) RangeSince(since time.Time, f func(string, T) error) error {
+	unlock := storage.rlockAll()
+	defer unlock()
+
+	names, err := storage.names()
+	if err != nil {
+		return fmt.Errorf("%w: failed to range JSONs: %s", ErrInternal, err)
+	}
+
+	all, err := storage.layeredNames(names)
+	if err != nil {
+		return fmt.Errorf("%w: failed to range JSONs: %s", ErrInternal, err)
+	}
+
+	for _, l := range all {
+		info, err := os.Stat(l.path())
 		if err != nil {
 			if errors.Is(err, os.ErrNotExist) {
 				continue
@@ -63,7 +431,11 @@ func (storage *Storage[T]) Range(f func(string, T) error) error {
 			return fmt.Errorf("%w: failed to range JSONs: %s", ErrInternal, err)
 		}
 
-		if err := f(ent.Key, ent.Value); err != nil {
+		if !info.ModTime().After(since) {
+			continue
+		}
+
+		if err := l.storage.rangeNames(context.Background(), []string{l.name}, f); err != nil {
 			return err
 		}
 	}
@@ -71,95 +443,1534 @@ func (storage *Storage[T]) Range(f func(string, T) error) error {
 	return nil
 }
 
-func (storage *Storage[T]) Get(key string) (T, error) {
-	key = strings.ToLower(key)
-	path := filepath.Join(storage.dir, url.PathEscape(key)+".json")
+// Page returns the entries in the window [offset, offset+limit) of the JSONs
+// sorted by file name, along with the total number of entries. A negative
+// limit means no limit. Every JSON is read to find the window, so that expired
+// entries and the ones skipped by WithSkipCorrupt are neither returned nor
+// counted in the total.
+func (storage *Storage[T]) Page(offset, limit int) ([]string, []T, int, error) {
+	unlock := storage.rlockAll()
+	defer unlock()
 
-	storage.mutex.RLock()
-	defer storage.mutex.RUnlock()
+	names, err := storage.names()
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("%w: failed to page JSONs: %s", ErrInternal, err)
+	}
 
-	ent := entry[T]{}
-	err := fstools.ReadFileFunc(path, func(r io.Reader) error {
-		return json.NewDecoder(r).Decode(&ent)
-	})
+	all, err := storage.layeredNames(names)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return *new(T), fmt.Errorf("%w: %s", ErrNotExist, key)
+		return nil, nil, 0, fmt.Errorf("%w: failed to page JSONs: %s", ErrInternal, err)
+	}
+	sortLayered(all)
+
+	if offset < 0 {
+		offset = 0
+	}
+
+	keys := []string{}
+	values := []T{}
+	total := 0
+	for _, l := range all {
+		ent, err := l.storage.get(l.path())
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if l.storage.skipCorrupt {
+				if l.storage.onCorrupt != nil {
+					key, _ := l.storage.key(l.name)
+					l.storage.onCorrupt(key, l.path(), err)
+				}
+				continue
+			}
+			return nil, nil, 0, fmt.Errorf("%w: failed to page JSONs: %s", ErrInternal, err)
+		}
+
+		if total >= offset && (limit < 0 || total < offset+limit) {
+			keys = append(keys, ent.Key)
+			values = append(values, ent.Value)
 		}
-		return *new(T), fmt.Errorf("%w: failed to get JSON: %s", ErrInternal, err)
+		total++
 	}
 
-	return ent.Value, nil
+	return keys, values, total, nil
 }
 
-func (storage *Storage[T]) Put(key string, value T) error {
-	key = strings.ToLower(key)
-	path := filepath.Join(storage.dir, url.PathEscape(key)+".json")
+func (storage *Storage[T]) Keys() ([]string, error) {
+	unlock := storage.rlockAll()
+	defer unlock()
 
-	storage.mutex.Lock()
-	defer storage.mutex.Unlock()
+	keys, err := storage.keys("")
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list keys: %s", ErrInternal, err)
+	}
 
-	err := fstools.WriteFileFunc(path, func(w io.Writer) error {
-		return json.NewEncoder(w).Encode(entry[T]{
-			Key:   key,
-			Value: value,
-		})
-	})
+	return keys, nil
+}
+
+// RangeKeys calls f with each key decoded from the file names, without
+// reading the JSONs.
+func (storage *Storage[T]) RangeKeys(f func(string) error) error {
+	unlock := storage.rlockAll()
+	defer unlock()
+
+	keys, err := storage.keys("")
 	if err != nil {
-		return fmt.Errorf("%w: failed to put JSON: %s", ErrInternal, err)
+		return fmt.Errorf("%w: failed to range keys: %s", ErrInternal, err)
+	}
+
+	for _, key := range keys {
+		if err := f(key); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (storage *Storage[T]) Edit(key string, f func(T) (T, error)) (T, error) {
-	key = strings.ToLower(key)
-	path := filepath.Join(storage.dir, url.PathEscape(key)+".json")
+func (storage *Storage[T]) KeysWithPrefix(prefix string) ([]string, error) {
+	unlock := storage.rlockAll()
+	defer unlock()
 
-	storage.mutex.Lock()
-	defer storage.mutex.Unlock()
+	keys, err := storage.keys(storage.normalize(prefix))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list keys: %s", ErrInternal, err)
+	}
 
-	ent := entry[T]{}
-	err := fstools.ReadFileFunc(path, func(r io.Reader) error {
-		return json.NewDecoder(r).Decode(&ent)
-	})
+	return keys, nil
+}
+
+// CountPrefix returns how many keys start with prefix, like
+// len(KeysWithPrefix(prefix)) but without building the list. Keys are decoded
+// from the file names, so no file is opened except for keys too long to be
+// escaped into a file name.
+func (storage *Storage[T]) CountPrefix(prefix string) (int, error) {
+	unlock := storage.rlockAll()
+	defer unlock()
+
+	names, err := storage.names()
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return *new(T), fmt.Errorf("%w: %s", ErrNotExist, key)
+		return 0, fmt.Errorf("%w: failed to count JSONs: %s", ErrInternal, err)
+	}
+
+	layers, err := storage.fallbackNames(names)
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to count JSONs: %s", ErrInternal, err)
+	}
+
+	prefix = storage.normalize(prefix)
+
+	count := storage.countPrefix(names, prefix)
+	for i, fallback := range storage.fallbacks {
+		count += fallback.countPrefix(layers[i], prefix)
+	}
+
+	return count, nil
+}
+
+func (storage *Storage[T]) countPrefix(names []string, prefix string) int {
+	count := 0
+	for _, name := range names {
+		key, err := storage.key(name)
+		if err != nil || !strings.HasPrefix(key, prefix) {
+			continue
 		}
-		return *new(T), fmt.Errorf("%w: failed to edit JSON: %s", ErrInternal, err)
+		count++
 	}
+	return count
+}
 
-	value, err := f(ent.Value)
+func (storage *Storage[T]) Count() (int, error) {
+	unlock := storage.rlockAll()
+	defer unlock()
+
+	names, err := storage.names()
 	if err != nil {
-		return *new(T), err
+		return 0, fmt.Errorf("%w: failed to count JSONs: %s", ErrInternal, err)
 	}
 
-	err = fstools.WriteFileFunc(path, func(w io.Writer) error {
-		return json.NewEncoder(w).Encode(entry[T]{
-			Key:   key,
-			Value: value,
-		})
-	})
+	layers, err := storage.fallbackNames(names)
 	if err != nil {
-		return *new(T), fmt.Errorf("%w: failed to edit JSON: %s", ErrInternal, err)
+		return 0, fmt.Errorf("%w: failed to count JSONs: %s", ErrInternal, err)
 	}
 
-	return value, nil
+	count := len(names)
+	for _, layer := range layers {
+		count += len(layer)
+	}
+
+	return count, nil
 }
 
-func (storage *Storage[T]) Delete(key string) error {
-	key = strings.ToLower(key)
-	path := filepath.Join(storage.dir, url.PathEscape(key)+".json")
+// DiskUsage returns the total size in bytes of the JSONs in the storage, with
+// WithDedup including the blobs they share and their reference counts.
+func (storage *Storage[T]) DiskUsage() (int64, error) {
+	unlock := storage.rlockAll()
+	defer unlock()
 
-	storage.mutex.Lock()
-	defer storage.mutex.Unlock()
+	names, err := storage.names()
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to measure JSONs: %s", ErrInternal, err)
+	}
 
-	if fstools.Exists(path) {
-		if err := os.Remove(path); err != nil {
-			return fmt.Errorf("%w: failed to delete JSON: %s", ErrInternal, err)
+	if storage.dedup {
+		blobNames, err := storage.blobNames()
+		if err != nil {
+			return 0, fmt.Errorf("%w: failed to measure JSONs: %s", ErrInternal, err)
 		}
+		names = append(names, blobNames...)
 	}
 
-	return nil
+	size := int64(0)
+	for _, name := range names {
+		info, err := os.Stat(filepath.Join(storage.dir, name))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return 0, fmt.Errorf("%w: failed to measure JSONs: %s", ErrInternal, err)
+		}
+		size += info.Size()
+	}
+
+	return size, nil
+}
+
+func (storage *Storage[T]) Get(key string) (T, error) {
+	return storage.GetContext(context.Background(), key)
+}
+
+func (storage *Storage[T]) GetContext(ctx context.Context, key string) (value T, err error) {
+	if storage.observer != nil {
+		defer storage.observe("get", key, time.Now(), &err)
+	}
+
+	if err := storage.checkKey(key); err != nil {
+		return *new(T), err
+	}
+
+	path := storage.path(key)
+
+	if storage.logger != nil {
+		storage.logger(ctx, "jsonstorage: get", "key", key, "path", path)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return *new(T), fmt.Errorf("%w: failed to get JSON: %s", err, key)
+	}
+
+	unlock := storage.rlockKey(path)
+	defer unlock()
+
+	if err := ctx.Err(); err != nil {
+		return *new(T), fmt.Errorf("%w: failed to get JSON: %s", err, key)
+	}
+
+	var ent entry[T]
+	err = storage.retry(ctx, func() (err error) {
+		ent, err = storage.get(path)
+		return err
+	})
+	if errors.Is(err, os.ErrNotExist) && len(storage.fallbacks) > 0 {
+		ent, err = storage.fallbackGet(key)
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return *new(T), &NotExistError{Key: key}
+		}
+		return *new(T), &InternalError{Key: key, Msg: "failed to get JSON", Err: err}
+	}
+
+	return ent.Value, nil
+}
+
+// GetOr returns def if key does not exist. Other errors are still returned.
+func (storage *Storage[T]) GetOr(key string, def T) (T, error) {
+	value, err := storage.Get(key)
+	if err != nil {
+		if errors.Is(err, ErrNotExist) {
+			return def, nil
+		}
+		return *new(T), err
+	}
+
+	return value, nil
+}
+
+// Lookup is like Get but reports a missing key with false instead of an error.
+func (storage *Storage[T]) Lookup(key string) (T, bool, error) {
+	value, err := storage.Get(key)
+	if err != nil {
+		if errors.Is(err, ErrNotExist) {
+			return *new(T), false, nil
+		}
+		return *new(T), false, err
+	}
+
+	return value, true, nil
+}
+
+// GetAll returns the values of the keys that exist, keyed as requested.
+// Missing keys are omitted unless WithStrictGetAll is set, in which case
+// ErrNotExist is returned.
+func (storage *Storage[T]) GetAll(keys ...string) (map[string]T, error) {
+	unlock := storage.rlockAll()
+	defer unlock()
+
+	values := make(map[string]T, len(keys))
+	for _, key := range keys {
+		if err := storage.checkKey(key); err != nil {
+			return nil, err
+		}
+
+		ent, err := storage.get(storage.path(key))
+		if errors.Is(err, os.ErrNotExist) && len(storage.fallbacks) > 0 {
+			ent, err = storage.fallbackGet(key)
+		}
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				if storage.strictGetAll {
+					return nil, &NotExistError{Key: key}
+				}
+				continue
+			}
+			return nil, &InternalError{Key: key, Msg: "failed to get JSON", Err: err}
+		}
+		values[key] = ent.Value
+	}
+
+	return values, nil
+}
+
+// Has reports whether the JSON of key exists without decoding it, so an
+// expired entry that has not been read since it expired is still reported.
+func (storage *Storage[T]) Has(key string) (bool, error) {
+	if err := storage.checkKey(key); err != nil {
+		return false, err
+	}
+
+	path := storage.path(key)
+
+	unlock := storage.rlockKey(path)
+	defer unlock()
+
+	if b, ok := storage.buffered(path); ok {
+		return !b.deleted, nil
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return false, &InternalError{Key: key, Msg: "failed to stat JSON", Err: err}
+		}
+		ok, err := storage.fallbackHas(key)
+		if err != nil {
+			return false, &InternalError{Key: key, Msg: "failed to stat JSON", Err: err}
+		}
+		return ok, nil
+	}
+
+	return true, nil
+}
+
+// Path returns the file the entry of key is stored in, reflecting the key
+// normalization, encoding, extension and sharding options. It does not access
+// the file system, so the file need not exist.
+func (storage *Storage[T]) Path(key string) string {
+	return storage.path(key)
+}
+
+func (storage *Storage[T]) Stat(key string) (os.FileInfo, error) {
+	if err := storage.checkKey(key); err != nil {
+		return nil, err
+	}
+
+	path := storage.path(key)
+
+	unlock := storage.rlockKey(path)
+	defer unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, &NotExistError{Key: key}
+		}
+		return nil, &InternalError{Key: key, Msg: "failed to stat JSON", Err: err}
+	}
+
+	return info, nil
+}
+
+// Touch sets the modification time of the JSON of key to now without
+// rewriting it.
+func (storage *Storage[T]) Touch(key string) error {
+	if err := storage.writable(); err != nil {
+		return err
+	}
+
+	if err := storage.checkKey(key); err != nil {
+		return err
+	}
+
+	path := storage.path(key)
+
+	unlock := storage.lockKey(path)
+	defer unlock()
+
+	now := storage.now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &NotExistError{Key: key}
+		}
+		return &InternalError{Key: key, Msg: "failed to touch JSON", Err: err}
+	}
+
+	return nil
+}
+
+func (storage *Storage[T]) Put(key string, value T) error {
+	return storage.PutContext(context.Background(), key, value)
+}
+
+func (storage *Storage[T]) PutContext(ctx context.Context, key string, value T) (err error) {
+	if storage.observer != nil {
+		defer storage.observe("put", key, time.Now(), &err)
+	}
+
+	if err := storage.writable(); err != nil {
+		return err
+	}
+
+	if err := storage.checkKey(key); err != nil {
+		return err
+	}
+
+	path := storage.path(key)
+
+	if storage.logger != nil {
+		storage.logger(ctx, "jsonstorage: put", "key", key, "path", path)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: failed to put JSON: %s", err, key)
+	}
+
+	unlock := storage.lockKey(path)
+	defer unlock()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: failed to put JSON: %s", err, key)
+	}
+
+	if err := storage.validate(key, value); err != nil {
+		return err
+	}
+
+	if err := storage.beforePut(key, value); err != nil {
+		return err
+	}
+
+	err = storage.retry(ctx, func() error {
+		return storage.put(path, entry[T]{Key: key, Value: value})
+	})
+	if err != nil {
+		return &InternalError{Key: key, Msg: "failed to put JSON", Err: err}
+	}
+
+	storage.afterPut(key, value)
+
+	return nil
+}
+
+// PutWithTTL is like Put but the entry expires after ttl. Expired entries are
+// treated as missing and removed when they are next read.
+func (storage *Storage[T]) PutWithTTL(key string, value T, ttl time.Duration) error {
+	if err := storage.writable(); err != nil {
+		return err
+	}
+
+	if err := storage.checkKey(key); err != nil {
+		return err
+	}
+
+	path := storage.path(key)
+
+	unlock := storage.lockKey(path)
+	defer unlock()
+
+	if err := storage.validate(key, value); err != nil {
+		return err
+	}
+
+	if err := storage.beforePut(key, value); err != nil {
+		return err
+	}
+
+	expiresAt := storage.now().Add(ttl)
+	if err := storage.put(path, entry[T]{Key: key, Value: value, entryMeta: entryMeta{ExpiresAt: &expiresAt}}); err != nil {
+		return &InternalError{Key: key, Msg: "failed to put JSON", Err: err}
+	}
+
+	storage.afterPut(key, value)
+
+	return nil
+}
+
+func (storage *Storage[T]) PutAll(entries map[string]T) error {
+	if err := storage.writable(); err != nil {
+		return err
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	if storage.strictValidation {
+		for key, value := range entries {
+			if err := storage.validate(key, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	for key, value := range entries {
+		if err := storage.checkKey(key); err != nil {
+			return err
+		}
+
+		if !storage.strictValidation {
+			if err := storage.validate(key, value); err != nil {
+				return err
+			}
+		}
+
+		if err := storage.beforePut(key, value); err != nil {
+			return err
+		}
+
+		if err := storage.put(storage.path(key), entry[T]{Key: key, Value: value}); err != nil {
+			return &InternalError{Key: key, Msg: "failed to put JSON", Err: err}
+		}
+
+		storage.afterPut(key, value)
+	}
+
+	return nil
+}
+
+func (storage *Storage[T]) PutIfAbsent(key string, value T) (bool, error) {
+	if err := storage.writable(); err != nil {
+		return false, err
+	}
+
+	if err := storage.checkKey(key); err != nil {
+		return false, err
+	}
+
+	path := storage.path(key)
+
+	unlock := storage.lockKey(path)
+	defer unlock()
+
+	if _, err := storage.get(path); err == nil {
+		return false, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false, &InternalError{Key: key, Msg: "failed to put JSON", Err: err}
+	}
+
+	if err := storage.validate(key, value); err != nil {
+		return false, err
+	}
+
+	if err := storage.beforePut(key, value); err != nil {
+		return false, err
+	}
+
+	if err := storage.put(path, entry[T]{Key: key, Value: value}); err != nil {
+		return false, &InternalError{Key: key, Msg: "failed to put JSON", Err: err}
+	}
+
+	storage.afterPut(key, value)
+
+	return true, nil
+}
+
+func (storage *Storage[T]) GetOrPut(key string, f func() (T, error)) (T, error) {
+	if err := storage.writable(); err != nil {
+		return *new(T), err
+	}
+
+	if err := storage.checkKey(key); err != nil {
+		return *new(T), err
+	}
+
+	path := storage.path(key)
+
+	unlock := storage.lockKey(path)
+	defer unlock()
+
+	ent, err := storage.get(path)
+	if err == nil {
+		return ent.Value, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return *new(T), &InternalError{Key: key, Msg: "failed to get JSON", Err: err}
+	}
+
+	value, err := f()
+	if err != nil {
+		return *new(T), err
+	}
+
+	if err := storage.validate(key, value); err != nil {
+		return *new(T), err
+	}
+
+	if err := storage.beforePut(key, value); err != nil {
+		return *new(T), err
+	}
+
+	if err := storage.put(path, entry[T]{Key: key, Value: value}); err != nil {
+		return *new(T), &InternalError{Key: key, Msg: "failed to put JSON", Err: err}
+	}
+
+	storage.afterPut(key, value)
+
+	return value, nil
+}
+
+func (storage *Storage[T]) Edit(key string, f func(T) (T, error)) (T, error) {
+	if err := storage.writable(); err != nil {
+		return *new(T), err
+	}
+
+	if err := storage.checkKey(key); err != nil {
+		return *new(T), err
+	}
+
+	path := storage.path(key)
+
+	unlock := storage.lockKey(path)
+	defer unlock()
+
+	ent, err := storage.get(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return *new(T), &NotExistError{Key: key}
+		}
+		return *new(T), &InternalError{Key: key, Msg: "failed to edit JSON", Err: err}
+	}
+
+	value, err := f(ent.Value)
+	if err != nil {
+		return *new(T), err
+	}
+
+	if err := storage.validate(key, value); err != nil {
+		return *new(T), err
+	}
+
+	if err := storage.beforePut(key, value); err != nil {
+		return *new(T), err
+	}
+
+	err = storage.put(path, entry[T]{Key: key, Value: value, entryMeta: ent.entryMeta})
+	if err != nil {
+		return *new(T), &InternalError{Key: key, Msg: "failed to edit JSON", Err: err}
+	}
+
+	storage.afterPut(key, value)
+
+	return value, nil
+}
+
+func (storage *Storage[T]) Update(key string, f func(T) (T, error)) error {
+	if err := storage.writable(); err != nil {
+		return err
+	}
+
+	if err := storage.checkKey(key); err != nil {
+		return err
+	}
+
+	path := storage.path(key)
+
+	unlock := storage.lockKey(path)
+	defer unlock()
+
+	ent, err := storage.get(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return &InternalError{Key: key, Msg: "failed to update JSON", Err: err}
+		}
+		ent = entry[T]{Key: key}
+	}
+
+	value, err := f(ent.Value)
+	if err != nil {
+		return err
+	}
+
+	if err := storage.validate(key, value); err != nil {
+		return err
+	}
+
+	if err := storage.beforePut(key, value); err != nil {
+		return err
+	}
+
+	if err := storage.put(path, entry[T]{Key: key, Value: value, entryMeta: ent.entryMeta}); err != nil {
+		return &InternalError{Key: key, Msg: "failed to update JSON", Err: err}
+	}
+
+	storage.afterPut(key, value)
+
+	return nil
+}
+
+// UpdateMany reads the existing entries of keys into a map, passes it to f and
+// writes back the map f returns, all under a single lock. Keys dropped from
+// the map by f are deleted. If f returns an error or any value is invalid,
+// nothing is written.
+func (storage *Storage[T]) UpdateMany(keys []string, f func(map[string]T) (map[string]T, error)) error {
+	if err := storage.writable(); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := storage.checkKey(key); err != nil {
+			return err
+		}
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	values := make(map[string]T, len(keys))
+	for _, key := range keys {
+		ent, err := storage.get(storage.path(key))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return &InternalError{Key: key, Msg: "failed to update JSON", Err: err}
+		}
+		values[key] = ent.Value
+	}
+
+	values, err := f(values)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range values {
+		if err := storage.checkKey(key); err != nil {
+			return err
+		}
+		if err := storage.validate(key, value); err != nil {
+			return err
+		}
+	}
+
+	for key, value := range values {
+		if err := storage.beforePut(key, value); err != nil {
+			return err
+		}
+
+		if err := storage.put(storage.path(key), entry[T]{Key: key, Value: value}); err != nil {
+			return &InternalError{Key: key, Msg: "failed to update JSON", Err: err}
+		}
+
+		storage.afterPut(key, value)
+	}
+
+	for _, key := range keys {
+		if _, ok := values[key]; ok {
+			continue
+		}
+
+		if err := storage.beforeDelete(key); err != nil {
+			return err
+		}
+
+		path := storage.path(key)
+		storage.cache.remove(path)
+
+		if err := storage.remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return &InternalError{Key: key, Msg: "failed to delete JSON", Err: err}
+		}
+
+		storage.afterDelete(key)
+	}
+
+	return nil
+}
+
+// CompareAndSwap stores newValue only if the current value is equal to oldValue
+// by reflect.DeepEqual. A missing key is regarded as having the zero value, so
+// it is swapped only when oldValue is the zero value.
+func (storage *Storage[T]) CompareAndSwap(key string, oldValue, newValue T) (bool, error) {
+	if err := storage.writable(); err != nil {
+		return false, err
+	}
+
+	if err := storage.checkKey(key); err != nil {
+		return false, err
+	}
+
+	path := storage.path(key)
+
+	unlock := storage.lockKey(path)
+	defer unlock()
+
+	ent, err := storage.get(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return false, &InternalError{Key: key, Msg: "failed to swap JSON", Err: err}
+		}
+		ent = entry[T]{Key: key}
+	}
+
+	if !reflect.DeepEqual(ent.Value, oldValue) {
+		return false, nil
+	}
+
+	if err := storage.validate(key, newValue); err != nil {
+		return false, err
+	}
+
+	if err := storage.beforePut(key, newValue); err != nil {
+		return false, err
+	}
+
+	if err := storage.put(path, entry[T]{Key: key, Value: newValue, entryMeta: ent.entryMeta}); err != nil {
+		return false, &InternalError{Key: key, Msg: "failed to swap JSON", Err: err}
+	}
+
+	storage.afterPut(key, newValue)
+
+	return true, nil
+}
+
+func (storage *Storage[T]) Delete(key string) error {
+	return storage.DeleteContext(context.Background(), key)
+}
+
+func (storage *Storage[T]) DeleteContext(ctx context.Context, key string) (err error) {
+	if storage.observer != nil {
+		defer storage.observe("delete", key, time.Now(), &err)
+	}
+
+	if err := storage.writable(); err != nil {
+		return err
+	}
+
+	if err := storage.checkKey(key); err != nil {
+		return err
+	}
+
+	path := storage.path(key)
+
+	if storage.logger != nil {
+		storage.logger(ctx, "jsonstorage: delete", "key", key, "path", path)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: failed to delete JSON: %s", err, key)
+	}
+
+	unlock := storage.lockKey(path)
+	defer unlock()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: failed to delete JSON: %s", err, key)
+	}
+
+	if err := storage.beforeDelete(key); err != nil {
+		return err
+	}
+
+	storage.cache.remove(path)
+
+	if storage.exists(path) {
+		err := storage.retry(ctx, func() error {
+			return storage.remove(path)
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return &InternalError{Key: key, Msg: "failed to delete JSON", Err: err}
+		}
+	}
+
+	storage.afterDelete(key)
+
+	return nil
+}
+
+// DeleteExisting is like Delete but reports whether the key existed and was
+// removed. The delete hooks run only when it does.
+func (storage *Storage[T]) DeleteExisting(key string) (bool, error) {
+	if err := storage.writable(); err != nil {
+		return false, err
+	}
+
+	if err := storage.checkKey(key); err != nil {
+		return false, err
+	}
+
+	path := storage.path(key)
+
+	unlock := storage.lockKey(path)
+	defer unlock()
+
+	if !storage.exists(path) {
+		return false, nil
+	}
+
+	if err := storage.beforeDelete(key); err != nil {
+		return false, err
+	}
+
+	storage.cache.remove(path)
+
+	err := storage.retry(context.Background(), func() error {
+		return storage.remove(path)
+	})
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, &InternalError{Key: key, Msg: "failed to delete JSON", Err: err}
+	}
+
+	storage.afterDelete(key)
+
+	return true, nil
+}
+
+// DeleteAll removes the JSONs of keys. It stops at the first failure.
+func (storage *Storage[T]) DeleteAll(keys ...string) error {
+	if err := storage.writable(); err != nil {
+		return err
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	for _, key := range keys {
+		if err := storage.checkKey(key); err != nil {
+			return err
+		}
+
+		if err := storage.beforeDelete(key); err != nil {
+			return err
+		}
+
+		path := storage.path(key)
+		storage.cache.remove(path)
+
+		if storage.exists(path) {
+			if err := storage.remove(path); err != nil {
+				return &InternalError{Key: key, Msg: "failed to delete JSON", Err: err}
+			}
+		}
+
+		storage.afterDelete(key)
+	}
+
+	return nil
+}
+
+// Clear removes every JSON in the storage, running the delete hooks for each
+// key. It stops at the first failure and leaves the remaining JSONs in place.
+func (storage *Storage[T]) Clear() error {
+	if err := storage.writable(); err != nil {
+		return err
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	storage.cache.clear()
+
+	names, err := storage.names()
+	if err != nil {
+		return fmt.Errorf("%w: failed to clear JSONs: %s", ErrInternal, err)
+	}
+
+	for _, name := range names {
+		// A hashed name whose JSON cannot be read has no key to report.
+		key, keyErr := storage.key(name)
+		if keyErr == nil {
+			if err := storage.beforeDelete(key); err != nil {
+				return err
+			}
+		}
+
+		if err := storage.remove(filepath.Join(storage.dir, name)); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return fmt.Errorf("%w: failed to clear JSONs: %s", ErrInternal, err)
+		}
+
+		if keyErr == nil {
+			storage.afterDelete(key)
+		}
+	}
+
+	return nil
+}
+
+func (storage *Storage[T]) observe(op string, key string, start time.Time, err *error) {
+	storage.observer(op, key, *err, time.Since(start))
+}
+
+// validate checks value with the configured validator, wrapping its error
+// with ErrInvalidValue.
+func (storage *Storage[T]) validate(key string, value T) error {
+	if storage.validator == nil {
+		return nil
+	}
+	if err := storage.validator(key, value); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidValue, key, err)
+	}
+	return nil
+}
+
+// validateKey rejects keys that are empty or consist only of whitespace.
+func validateKey(key string) error {
+	if strings.TrimSpace(key) == "" {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return nil
+}
+
+// checkKey validates key and makes sure that its file stays inside the
+// storage directory, whatever the key encoder produces for it.
+func (storage *Storage[T]) checkKey(key string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	path := storage.path(key)
+	rel, err := filepath.Rel(storage.dir, path)
+	if err != nil || !filepath.IsLocal(rel) || strings.ContainsRune(path, 0) {
+		return fmt.Errorf("%w: file name escapes the storage directory: %q", ErrInvalidKey, key)
+	}
+
+	return nil
+}
+
+func (storage *Storage[T]) normalize(key string) string {
+	return storage.normalizeKey(key)
+}
+
+// hashPrefix marks file names holding the SHA-256 of a key too long to be
+// escaped into a file name. url.PathEscape always escapes '#', so it never
+// collides with an escaped key.
+const hashPrefix = "#"
+
+func (storage *Storage[T]) path(key string) string {
+	key = storage.normalize(key)
+
+	dir, name := "", storage.encodeKey(key)
+	if storage.hierarchical {
+		dir, name = storage.splitKey(key)
+	}
+
+	if len(name)+len(storage.ext) > storage.maxNameLength && !storage.flat {
+		sum := sha256.Sum256([]byte(key))
+		name = hashPrefix + hex.EncodeToString(sum[:])
+	}
+
+	if !storage.hierarchical {
+		dir = storage.shard(name)
+	}
+
+	return filepath.Join(storage.dir, dir, name+storage.ext)
+}
+
+// shard returns the shard directories of the file name, derived from its
+// SHA-256, e.g. "ab/cd" for a depth of 2.
+func (storage *Storage[T]) shard(name string) string {
+	if storage.shardDepth == 0 {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	dirs := make([]string, storage.shardDepth)
+	for i := range dirs {
+		dirs[i] = hex.EncodeToString(sum[i : i+1])
+	}
+	return filepath.Join(dirs...)
+}
+
+// key decodes the key from the file name, reading the JSON if the name is a
+// hash of the key.
+func (storage *Storage[T]) key(name string) (string, error) {
+	if storage.hashed(name) && !storage.flat {
+		ent, err := storage.get(filepath.Join(storage.dir, name))
+		if err != nil {
+			return "", err
+		}
+		return storage.normalize(ent.Key), nil
+	}
+	if storage.hierarchical {
+		return storage.joinKey(strings.TrimSuffix(name, storage.ext))
+	}
+	return storage.decodeKey(strings.TrimSuffix(filepath.Base(name), storage.ext))
+}
+
+func (storage *Storage[T]) hashed(name string) bool {
+	return strings.HasPrefix(filepath.Base(name), hashPrefix)
+}
+
+func (storage *Storage[T]) rangeNames(ctx context.Context, names []string, f func(string, T) error) error {
+	for _, name := range names {
+		path := filepath.Join(storage.dir, name)
+
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("%w: failed to range JSONs", err)
+		}
+
+		if storage.logger != nil {
+			storage.logger(ctx, "jsonstorage: range", "path", path)
+		}
+
+		ent, err := storage.get(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if storage.skipCorrupt {
+				if storage.onCorrupt != nil {
+					key, _ := storage.key(name)
+					storage.onCorrupt(key, path, err)
+				}
+				continue
+			}
+			return fmt.Errorf("%w: failed to range JSONs: %w", ErrInternal, err)
+		}
+
+		if err := f(ent.Key, ent.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// names lists the paths of the JSONs relative to the storage directory,
+// descending into the shard directories if sharding is enabled.
+func (storage *Storage[T]) names() ([]string, error) {
+	names := []string{}
+	if err := storage.walk("", storage.walkDepth(), storage.ext, &names); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, storage.dirIsFile(err)
+		}
+	}
+	return storage.bufferNames(names), nil
+}
+
+// walk collects the paths of the files under dir ending with suffix.
+func (storage *Storage[T]) walk(dir string, depth int, suffix string, names *[]string) error {
+	direntries, err := fstools.ReadDir(filepath.Join(storage.dir, dir))
+	if err != nil {
+		return err
+	}
+
+	for _, direntry := range direntries {
+		name := direntry.Name()
+
+		if direntry.IsDir() {
+			if depth == 0 && !storage.hierarchical {
+				continue
+			}
+			if dir == "" && name == blobDir && storage.dedup {
+				continue
+			}
+			if err := storage.walk(filepath.Join(dir, name), depth-1, suffix, names); err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				return err
+			}
+			continue
+		}
+
+		if depth > 0 {
+			continue
+		}
+
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+
+		if !storage.hashed(name) {
+			if key, err := storage.key(strings.TrimSuffix(name, tombstoneExt)); err != nil || validateKey(key) != nil {
+				continue
+			}
+		}
+
+		*names = append(*names, filepath.Join(dir, name))
+	}
+
+	return nil
+}
+
+// keys lists the keys starting with prefix, decoded from the file names.
+func (storage *Storage[T]) keys(prefix string) ([]string, error) {
+	names, err := storage.names()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for _, name := range names {
+		key, err := storage.key(name)
+		if err != nil {
+			continue
+		}
+
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	layers, err := storage.fallbackNames(names)
+	if err != nil {
+		return nil, err
+	}
+	for i, fallback := range storage.fallbacks {
+		for _, name := range layers[i] {
+			key, err := fallback.key(name)
+			if err != nil || !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// get reads the entry at path. Expired entries are removed and reported as
+// os.ErrNotExist; this is safe under a read lock since writers are excluded.
+func (storage *Storage[T]) get(path string) (entry[T], error) {
+	if b, ok := storage.buffered(path); ok {
+		if b.deleted || (b.ent.ExpiresAt != nil && !storage.now().Before(*b.ent.ExpiresAt)) {
+			return entry[T]{}, os.ErrNotExist
+		}
+		return b.ent, nil
+	}
+
+	if ent, ok := storage.cache.get(path); ok {
+		storage.touch(path)
+		return storage.expire(path, ent)
+	}
+
+	ent := entry[T]{}
+	err := fstools.ReadFileFunc(path, func(r io.Reader) error {
+		if err := storage.decodeEntry(r, path, &ent); err != nil {
+			return err
+		}
+		return backfill(r, &ent.entryMeta)
+	})
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return entry[T]{}, storage.dirIsFile(err)
+		}
+		return entry[T]{}, err
+	}
+
+	storage.cache.set(path, ent)
+
+	storage.touch(path)
+
+	return storage.expire(path, ent)
+}
+
+// decodeEntry reads ent from r, the file at path, as written by put: flat or
+// wrapped, migrated from an older schema version, with its value in a blob.
+// The checksum is verified as well.
+func (storage *Storage[T]) decodeEntry(r io.Reader, path string, ent *entry[T]) error {
+	if storage.flat {
+		key, err := storage.decodeFlat(r, path, &ent.Value)
+		if err != nil {
+			return err
+		}
+		ent.Key = key
+	} else if storage.migrate != nil || storage.checksum {
+		if err := storage.decodeRaw(r, ent); err != nil {
+			return err
+		}
+	} else if err := storage.decode(r, ent); err != nil {
+		return err
+	}
+
+	if ent.Key == "" {
+		return errNoWrapper
+	}
+
+	if ent.Blob != "" {
+		if err := storage.readBlob(ent.Blob, &ent.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeRaw reads ent from r through its raw value, which is verified against
+// the checksum before being decoded, or converted with the migration if it was
+// written with an older schema version.
+func (storage *Storage[T]) decodeRaw(r io.Reader, ent *entry[T]) error {
+	raw := entry[json.RawMessage]{}
+	if err := storage.decode(r, &raw); err != nil {
+		return err
+	}
+
+	// The value of a deduplicated entry is in its blob, which is resolved here
+	// rather than by get so that the checksum and the migration see the value.
+	if raw.Blob != "" {
+		if err := storage.readBlob(raw.Blob, &raw.Value); err != nil {
+			return err
+		}
+	}
+
+	ent.Key, ent.entryMeta = raw.Key, raw.entryMeta
+	ent.Blob = ""
+
+	// Without a key the file is no entry, which decodeEntry reports.
+	if raw.Key == "" {
+		return nil
+	}
+
+	if err := storage.verifyChecksum(raw.Key, raw.Checksum, raw.Value); err != nil {
+		return err
+	}
+
+	if raw.SchemaVersion < storage.schemaVersion && storage.migrate != nil {
+		// The checksum is of the value as written, not as migrated.
+		ent.Checksum = ""
+
+		value, err := storage.migrate(raw.SchemaVersion, raw.Value)
+		if err != nil {
+			return fmt.Errorf("failed to migrate from version %d: %w", raw.SchemaVersion, err)
+		}
+		ent.Value = value
+		return nil
+	}
+
+	if raw.Value == nil {
+		return nil
+	}
+
+	if err := storage.newDecoder(bytes.NewReader(raw.Value)).Decode(&ent.Value); err != nil {
+		return fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+	return nil
+}
+
+func (storage *Storage[T]) expire(path string, ent entry[T]) (entry[T], error) {
+	if ent.ExpiresAt == nil || storage.now().Before(*ent.ExpiresAt) {
+		return ent, nil
+	}
+
+	if storage.readOnly {
+		return entry[T]{}, os.ErrNotExist
+	}
+
+	storage.cache.remove(path)
+	if err := storage.unlink(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return entry[T]{}, err
+	}
+
+	return entry[T]{}, os.ErrNotExist
+}
+
+// put writes ent atomically: fstools.WriteFileFunc encodes into a temporary
+// file named "<name><random>" in the same directory, which never matches the
+// extension, and renames it into place only after a successful write.
+func (storage *Storage[T]) put(path string, ent entry[T]) error {
+	if err := storage.closed(); err != nil {
+		return err
+	}
+
+	ent, err := storage.stamp(path, ent)
+	if err != nil {
+		return err
+	}
+
+	// Evicting here rather than on Flush keeps the buffered writes within
+	// WithMaxEntries too.
+	if err := storage.evict(path); err != nil {
+		return err
+	}
+
+	if storage.buffer != nil {
+		storage.bufferPut(path, buffered[T]{ent: ent, at: storage.now()})
+		return nil
+	}
+
+	return storage.putFile(path, ent)
+}
+
+// stamp fills in the metadata written with ent, as stampMeta does. With
+// WithFlatFormat, it rejects values that would be read back as an entry.
+func (storage *Storage[T]) stamp(path string, ent entry[T]) (entry[T], error) {
+	_, isJSON := storage.codec.(jsonCodec)
+
+	raw := []byte(nil)
+	if (storage.checksum && !storage.flat) || (storage.flat && isJSON) {
+		b, err := json.Marshal(ent.Value)
+		if err != nil {
+			return entry[T]{}, fmt.Errorf("%w: %w", ErrEncode, err)
+		}
+		raw = b
+	}
+
+	if storage.flat && isJSON {
+		if err := storage.checkFlat(ent.Key, path, raw); err != nil {
+			return entry[T]{}, err
+		}
+	}
+
+	meta, err := storage.stampMeta(path, ent.entryMeta, raw)
+	if err != nil {
+		return entry[T]{}, err
+	}
+	ent.entryMeta = meta
+
+	return ent, nil
+}
+
+// stampMeta fills in meta, written at path with the value encoded as raw: the
+// schema version, the version and timestamps following the entry at path, and
+// the checksum of raw.
+func (storage *Storage[T]) stampMeta(path string, meta entryMeta, raw []byte) (entryMeta, error) {
+	// Flat files keep no metadata, so neither does the cached entry.
+	if storage.flat {
+		return entryMeta{}, nil
+	}
+
+	meta.SchemaVersion = storage.schemaVersion
+	meta.Blob = ""
+
+	// A file that cannot be decoded is overwritten as if it did not exist.
+	prev, _ := storage.meta(path)
+	now := storage.now()
+	meta.Version = prev.Version + 1
+	meta.CreatedAt, meta.UpdatedAt = prev.CreatedAt, &now
+	if meta.CreatedAt == nil {
+		meta.CreatedAt = &now
+	}
+
+	meta.Checksum = ""
+	if storage.checksum {
+		sum, err := checksum(raw)
+		if err != nil {
+			return entryMeta{}, fmt.Errorf("%w: %w", ErrEncode, err)
+		}
+		meta.Checksum = sum
+	}
+
+	return meta, nil
+}
+
+// putFile writes ent to the file at path, bypassing the write buffer. Room
+// for a new entry must have been made with evict.
+func (storage *Storage[T]) putFile(path string, ent entry[T]) error {
+	storage.cache.remove(path)
+
+	if storage.dedup && !storage.flat {
+		if err := storage.putDedup(path, ent.Key, ent.entryMeta, ent.Value); err != nil {
+			return err
+		}
+		storage.cache.set(path, ent)
+		storage.touch(path)
+		return nil
+	}
+
+	err := storage.writeFile(path, func(w io.Writer) error {
+		return storage.encode(w, storage.stored(ent))
+	})
+	if err != nil {
+		return err
+	}
+
+	storage.cache.set(path, ent)
+	storage.touch(path)
+
+	return nil
+}
+
+// decode reads v from r, decrypting and decompressing as configured. Failures
+// to read r are returned as is, any other failure wrapped with ErrDecode.
+func (storage *Storage[T]) decode(r io.Reader, v any) error {
+	er := &errReader{r: r}
+	if err := storage.decodeStream(er, v); err != nil {
+		if er.err != nil {
+			return er.err
+		}
+		return fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+	return nil
+}
+
+func (storage *Storage[T]) decodeStream(r io.Reader, v any) error {
+	if storage.aead != nil {
+		plain, err := storage.decrypt(r)
+		if err != nil {
+			return err
+		}
+		r = plain
+	}
+
+	if storage.compress {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	return storage.codec.Decode(r, v)
+}
+
+// encode writes v to w, compressing and encrypting as configured. Failures
+// to write w are returned as is, any other failure wrapped with ErrEncode.
+func (storage *Storage[T]) encode(w io.Writer, v any) error {
+	ew := &errWriter{w: w}
+	if err := storage.encodeStream(ew, v); err != nil {
+		if ew.err != nil {
+			return ew.err
+		}
+		return fmt.Errorf("%w: %w", ErrEncode, err)
+	}
+	return nil
+}
+
+func (storage *Storage[T]) encodeStream(w io.Writer, v any) error {
+	if storage.aead != nil {
+		return storage.encrypt(w, func(w io.Writer) error {
+			return storage.compressEncode(w, v)
+		})
+	}
+
+	return storage.compressEncode(w, v)
+}
+
+func (storage *Storage[T]) compressEncode(w io.Writer, v any) error {
+	if storage.compress {
+		gw := gzip.NewWriter(w)
+		if err := storage.codec.Encode(gw, v); err != nil {
+			gw.Close()
+			return err
+		}
+		return gw.Close()
+	}
+
+	return storage.codec.Encode(w, v)
 }
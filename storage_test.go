@@ -0,0 +1,95 @@
+package jsonstorage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newRawKeyStorage returns a Storage whose key encoder leaves keys as is, so
+// that only checkKey stands between a key and the file system.
+func newRawKeyStorage(t *testing.T) (*Storage[string], string) {
+	t.Helper()
+
+	root := t.TempDir()
+	identity := func(key string) string { return key }
+	decode := func(name string) (string, error) { return name, nil }
+
+	return New(filepath.Join(root, "storage"), WithCaseSensitive[string](), WithKeyEncoder[string](identity, decode)), root
+}
+
+func TestCheckKeyRejectsTraversal(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{name: "parent", key: "../escape"},
+		{name: "nested parent", key: "a/../../escape"},
+		{name: "absolute parent", key: "/../escape"},
+		{name: "null byte", key: "a\x00b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage, root := newRawKeyStorage(t)
+
+			// A JSON the key would resolve to if it escaped the storage dir.
+			outside := filepath.Join(root, "escape.json")
+			if err := os.WriteFile(outside, []byte(`{"key":"../escape","value":"outside"}`), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := storage.Put(tt.key, "value"); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Put(%q) = %v, want ErrInvalidKey", tt.key, err)
+			}
+
+			if value, err := storage.Get(tt.key); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Get(%q) = %q, %v, want ErrInvalidKey", tt.key, value, err)
+			}
+
+			if err := storage.Delete(tt.key); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Delete(%q) = %v, want ErrInvalidKey", tt.key, err)
+			}
+
+			data, err := os.ReadFile(outside)
+			if err != nil {
+				t.Fatalf("JSON outside the storage dir: %v", err)
+			}
+			if string(data) != `{"key":"../escape","value":"outside"}` {
+				t.Errorf("JSON outside the storage dir = %s, want it untouched", data)
+			}
+		})
+	}
+}
+
+func TestCheckKeyKeepsAbsoluteKeysInside(t *testing.T) {
+	storage, root := newRawKeyStorage(t)
+
+	key := filepath.Join(root, "absolute")
+
+	if err := storage.Put(key, "value"); err != nil {
+		t.Fatalf("Put(%q) = %v", key, err)
+	}
+
+	if value, err := storage.Get(key); err != nil || value != "value" {
+		t.Errorf("Get(%q) = %q, %v, want %q", key, value, err, "value")
+	}
+
+	if _, err := os.Stat(key + ".json"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("JSON written to the absolute path %s: %v", key, err)
+	}
+
+	rel, err := filepath.Rel(storage.dir, storage.Path(key))
+	if err != nil || !filepath.IsLocal(rel) {
+		t.Errorf("Path(%q) = %s, want it inside %s", key, storage.Path(key), storage.dir)
+	}
+
+	if err := storage.Delete(key); err != nil {
+		t.Errorf("Delete(%q) = %v", key, err)
+	}
+
+	if ok, err := storage.Has(key); err != nil || ok {
+		t.Errorf("Has(%q) = %v, %v after Delete, want false", key, ok, err)
+	}
+}
@@ -0,0 +1,15 @@
+package jsonstorage
+
+// Store is the set of operations shared by the storage backends.
+type Store[T any] interface {
+	Range(f func(string, T) error) error
+	Keys() ([]string, error)
+	Get(key string) (T, error)
+	Has(key string) (bool, error)
+	Put(key string, value T) error
+	Edit(key string, f func(T) (T, error)) (T, error)
+	Update(key string, f func(T) (T, error)) error
+	Delete(key string) error
+}
+
+var _ Store[any] = (*Storage[any])(nil)
@@ -0,0 +1,46 @@
+package jsonstorage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/thamaji/fstools"
+)
+
+// Verify decodes every JSON in the storage the way Get does, migrating and
+// resolving blobs but bypassing the cache, and returns the paths of the ones
+// that fail to decode or, with WithChecksum, whose checksum does not match.
+// Failures to read a file are returned as an error instead. Nothing is
+// modified.
+func (storage *Storage[T]) Verify() ([]string, error) {
+	unlock := storage.rlockAll()
+	defer unlock()
+
+	names, err := storage.names()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to verify JSONs: %s", ErrInternal, err)
+	}
+
+	corrupt := []string{}
+	for _, name := range names {
+		path := filepath.Join(storage.dir, name)
+
+		err := fstools.ReadFileFunc(path, func(r io.Reader) error {
+			return storage.decodeEntry(r, path, &entry[T]{})
+		})
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if !errors.Is(err, ErrDecode) && !errors.Is(err, ErrChecksumMismatch) {
+				return nil, fmt.Errorf("%w: failed to verify JSONs: %w", ErrInternal, err)
+			}
+			corrupt = append(corrupt, path)
+		}
+	}
+
+	return corrupt, nil
+}
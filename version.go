@@ -0,0 +1,90 @@
+package jsonstorage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// GetVersioned is like Get but also returns the version of the entry, which
+// starts at 1 and is incremented by each write. JSONs written before entries
+// were versioned, and entries read from a fallback directory, are version 0.
+func (storage *Storage[T]) GetVersioned(key string) (T, uint64, error) {
+	if err := storage.checkKey(key); err != nil {
+		return *new(T), 0, err
+	}
+
+	path := storage.path(key)
+
+	unlock := storage.rlockKey(path)
+	defer unlock()
+
+	ent, err := storage.get(path)
+	if errors.Is(err, os.ErrNotExist) && len(storage.fallbacks) > 0 {
+		ent, err = storage.fallbackGet(key)
+		// Writes go to the storage directory, where the key is at version 0.
+		ent.Version = 0
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return *new(T), 0, &NotExistError{Key: key}
+		}
+		return *new(T), 0, &InternalError{Key: key, Msg: "failed to get JSON", Err: err}
+	}
+
+	return ent.Value, ent.Version, nil
+}
+
+// PutVersioned is like Put but fails with ErrVersionConflict unless the entry
+// is still at expectedVersion, as returned by GetVersioned. A key that does
+// not exist is at version 0.
+func (storage *Storage[T]) PutVersioned(key string, value T, expectedVersion uint64) error {
+	if err := storage.writable(); err != nil {
+		return err
+	}
+
+	if err := storage.checkKey(key); err != nil {
+		return err
+	}
+
+	path := storage.path(key)
+
+	unlock := storage.lockKey(path)
+	defer unlock()
+
+	version, err := storage.version(path)
+	if err != nil {
+		return &InternalError{Key: key, Msg: "failed to put JSON", Err: err}
+	}
+	if version != expectedVersion {
+		return fmt.Errorf("%w: %s: expected version %d, found %d", ErrVersionConflict, key, expectedVersion, version)
+	}
+
+	if err := storage.validate(key, value); err != nil {
+		return err
+	}
+
+	if err := storage.beforePut(key, value); err != nil {
+		return err
+	}
+
+	if err := storage.put(path, entry[T]{Key: key, Value: value}); err != nil {
+		return &InternalError{Key: key, Msg: "failed to put JSON", Err: err}
+	}
+
+	storage.afterPut(key, value)
+
+	return nil
+}
+
+// version returns the version of the entry at path, or 0 if there is none.
+func (storage *Storage[T]) version(path string) (uint64, error) {
+	meta, err := storage.meta(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return meta.Version, nil
+}
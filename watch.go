@@ -0,0 +1,144 @@
+package jsonstorage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/thamaji/fstools"
+)
+
+type EventOp int
+
+const (
+	EventPut EventOp = iota + 1
+	EventDelete
+)
+
+func (op EventOp) String() string {
+	switch op {
+	case EventPut:
+		return "put"
+	case EventDelete:
+		return "delete"
+	default:
+		return fmt.Sprintf("EventOp(%d)", int(op))
+	}
+}
+
+type Event struct {
+	Op  EventOp
+	Key string
+}
+
+// watchDebounce is how long changes to the same JSON are coalesced into one
+// event.
+const watchDebounce = 100 * time.Millisecond
+
+// Watch reports the JSONs put or deleted in the storage directory, by this or
+// any other process, until ctx is done or the storage is closed. Rapid
+// changes to the same JSON are coalesced into a single event reflecting
+// whether it exists afterwards. The storage directory is created if it does
+// not exist, unless the storage is read-only. Only the storage directory
+// itself is watched, so changes within shard directories are not reported.
+// It fails with ErrClosed once the storage is closed.
+func (storage *Storage[T]) Watch(ctx context.Context) (<-chan Event, error) {
+	if err := storage.closed(); err != nil {
+		return nil, err
+	}
+
+	if !storage.readOnly {
+		if err := storage.mkdirAll(storage.dir); err != nil {
+			return nil, fmt.Errorf("%w: failed to watch JSONs: %s", ErrInternal, err)
+		}
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to watch JSONs: %s", ErrInternal, err)
+	}
+
+	if err := watcher.Add(storage.dir); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("%w: failed to watch JSONs: %s", ErrInternal, err)
+	}
+
+	events := make(chan Event)
+	go storage.watch(ctx, watcher, events)
+
+	return events, nil
+}
+
+func (storage *Storage[T]) watch(ctx context.Context, watcher *fsnotify.Watcher, events chan<- Event) {
+	defer close(events)
+	defer watcher.Close()
+
+	// Canceled on return, so that pending timers do not block on fire.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	timers := map[string]*time.Timer{}
+	defer func() {
+		for _, timer := range timers {
+			timer.Stop()
+		}
+	}()
+
+	fire := make(chan string)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-storage.done:
+			return
+
+		case <-watcher.Errors:
+
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+
+			name := filepath.Base(ev.Name)
+			if !strings.HasSuffix(name, storage.ext) {
+				continue
+			}
+
+			if timer, ok := timers[name]; ok {
+				timer.Reset(watchDebounce)
+				continue
+			}
+
+			timers[name] = time.AfterFunc(watchDebounce, func() {
+				select {
+				case fire <- name:
+				case <-ctx.Done():
+				}
+			})
+
+		case name := <-fire:
+			delete(timers, name)
+
+			key, err := storage.key(name)
+			if err != nil {
+				continue
+			}
+
+			ev := Event{Op: EventDelete, Key: key}
+			if fstools.Exists(filepath.Join(storage.dir, name)) {
+				ev.Op = EventPut
+			}
+
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}